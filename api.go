@@ -19,69 +19,574 @@ package socketcmd
 */
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
+/* ErrModeCommand is returned for control commands which switch a socket connection into
+ * another mode (SessionCommand, SubscribeCommand and AttachCommand), since the connection
+ * would outlive the HTTP request. Events are streamed by the stream endpoint instead.
+ */
+var ErrModeCommand = errors.New("the command switches the connection mode and cannot be sent over HTTP")
+
 /* A WrapperAPI extends an enclosed Wrapper with high-level remote API operations.
  */
 type WrapperAPI interface {
 	Wrapper
-	/* Listen on the given address and serve the command endpoint at the given path.
+	/* ServeHTTP routes requests to the endpoints of the WrapperAPI through its middleware.
+	 * The command endpoint is served at "/" (and the path given to Listen), the batch,
+	 * metrics, health, readiness, signal, stream and completion endpoints at "/batch",
+	 * "/metrics", "/healthz", "/readyz", "/signal", "/stream" and "/complete" respectively,
+	 * the typed command routes under "/commands/", their OpenAPI description at
+	 * "/openapi.json" and the web console (if enabled) under UIPath.
+	 */
+	http.Handler
+	/* Listen on the given address and serve the WrapperAPI, with the command endpoint also
+	 * at the given path. The address is either a TCP address or "unix:" followed by the
+	 * path of a Unix domain socket. Listen returns http.ErrServerClosed after Shutdown.
 	 */
 	Listen(addr, path string) error
+	// ListenTLS listens on the given address like Listen, and serves HTTPS.
+	ListenTLS(addr, path, certFile, keyFile string) error
+	// Serve the WrapperAPI on the given listener.
+	Serve(net.Listener) error
+	/* Shutdown gracefully shuts down the HTTP servers of the WrapperAPI, waiting for active
+	 * requests to complete until the context is done.
+	 */
+	Shutdown(context.Context) error
+	// Use adds middleware to the WrapperAPI. The first middleware added is the outermost.
+	Use(...Middleware)
+	/* Server sets the configuration (timeouts, TLS configuration and error log) of the
+	 * HTTP servers started by the WrapperAPI. The APIReadHeaderTimeout, APIReadTimeout,
+	 * APIWriteTimeout and APIIdleTimeout are used otherwise.
+	 */
+	Server(*http.Server)
 	/* Default Handler function for the WrapperAPI. This method may be used to integrate
 	 * the WrapperAPI into an existing API or extend it with other endpoints. This endpoint
-	 * expects to receive a command sequence as an array of strings in JSON format. If the
-	 * first element is a valid socketcmd Header then it will be used to parse the response.
-	 * Otherwise, the configured ParseFunc will be used to generate a header based on the
-	 * given command sequence. The response will by sent back as a JSON array of strings.
+	 * expects to receive a POST request with a command sequence as an array of strings in
+	 * JSON format. If the first element is a valid socketcmd Header then it will be used to
+	 * parse the response. Otherwise, the configured ParseFunc will be used to generate a
+	 * header based on the given command sequence. The command may also be sent as a JSON
+	 * object ({"command": [...], "lines": 1, "timeout": 500}), as text/plain with one command
+	 * per line, or as a form with "command", "lines" and "timeout" fields. Several commands
+	 * are sent in order over one session, and their responses are concatenated.
+	 *
+	 * The response is sent back as a JSON array of strings, or as text/plain lines or
+	 * application/x-ndjson according to the Accept header. If the request accepts
+	 * "application/json; structured", the response is instead the JSON object extracted by
+	 * the command's Extractor in the Wrapper's Policy. Clients that exceed the Wrapper's
+	 * rate limit receive a 429 status with Retry-After, request bodies larger than
+	 * MaxRequestBody receive a 413 status, and commands switching the connection mode
+	 * (ErrModeCommand) receive a 400 status. The exchange ends when the request does.
 	 */
 	CommandEndpoint(http.ResponseWriter, *http.Request)
+	/* Batch endpoint for the WrapperAPI. This endpoint expects to receive a JSON object with
+	 * an array of command sequences, which are sent over one session:
+	 *
+	 *	{"commands": [["whitelist", "add", "alice"], ["whitelist", "add", "bob"]], "continue": true}
+	 *
+	 * Unless "continue" is set, the commands after the first which fails are skipped. The
+	 * response is a JSON array with the result of each command, as an object with the
+	 * response "lines" and any "error" (and its "status", if reported by the Handler).
+	 */
+	BatchEndpoint(http.ResponseWriter, *http.Request)
+	/* Typed command endpoint for the WrapperAPI, serving a route under CommandsPath for
+	 * each allowed command in the Policy which defines parameters or has no subcommands.
+	 * The route expects to receive the parameter values as a JSON object, for example
+	 * {"player": "alice"} for POST /commands/whitelist/add. The response is as for the
+	 * command endpoint.
+	 */
+	CommandsEndpoint(http.ResponseWriter, *http.Request)
+	/* OpenAPI endpoint for the WrapperAPI. Responds with an OpenAPI 3 document describing
+	 * the typed command routes of the current Policy.
+	 */
+	OpenAPIEndpoint(http.ResponseWriter, *http.Request)
+	/* Metrics endpoint for the WrapperAPI. Handler and process metrics are served in the
+	 * Prometheus text exposition format.
+	 */
+	MetricsEndpoint(http.ResponseWriter, *http.Request)
+	/* Health endpoint for the WrapperAPI. Responds with the Wrapper's Health as a JSON
+	 * object, with a 503 status unless the process is running with its stdout open.
+	 */
+	HealthEndpoint(http.ResponseWriter, *http.Request)
+	/* Readiness endpoint for the WrapperAPI. Responds with the Wrapper's Health as a JSON
+	 * object, with a 503 status unless the process is ready to receive commands.
+	 */
+	ReadyEndpoint(http.ResponseWriter, *http.Request)
+	/* Signal endpoint for the WrapperAPI. This endpoint expects to receive a signal name
+	 * (such as "HUP") as a JSON string, and sends it to the wrapped process if the Wrapper's
+	 * Policy allows the corresponding !signal control command.
+	 */
+	SignalEndpoint(http.ResponseWriter, *http.Request)
+	/* Stream endpoint for the WrapperAPI. Streams the Wrapper's events, such as the output
+	 * of the wrapped process, as server-sent events named after the kind of event, with the
+	 * event as JSON data. The "kind" query parameter selects the kinds of event to stream,
+	 * e.g. "?kind=stdout,command". Streams are authorized like the !subscribe control
+	 * command.
+	 */
+	StreamEndpoint(http.ResponseWriter, *http.Request)
+	/* Completion endpoint for the WrapperAPI. Responds with the completions of the command
+	 * given as typed in the "q" query parameter (e.g. "?q=whitelist+a"), as a JSON array of
+	 * Candidates which are allowed by the Wrapper's Policy or have allowed subcommands. A
+	 * trailing space completes the next argument. Completions are authorized like the
+	 * !complete control command.
+	 */
+	CompleteEndpoint(http.ResponseWriter, *http.Request)
+	/* UI enables the web console of the WrapperAPI, which is disabled by default. The
+	 * console shows the live output of the wrapped process and the health status, and
+	 * sends commands with history and autocompletion from the Wrapper's Policy. Its
+	 * assets are embedded, so it works without access to the internet.
+	 */
+	UI(enabled bool)
+	// UI endpoint for the WrapperAPI, serving the web console under UIPath.
+	UIEndpoint(http.ResponseWriter, *http.Request)
 }
 
 type wrapperAPI struct {
 	*wrapper
-	c Client
+	*apiServer
+	c *client
+	// Whether the web console is enabled
+	ui atomic.Bool
+}
+
+func newWrapperAPI(w *wrapper, c *client) *wrapperAPI {
+	api := &wrapperAPI{wrapper: w, apiServer: newAPIServer(), c: c}
+	api.handle("/", func(w http.ResponseWriter, r *http.Request) {
+		// Other unregistered paths are not command endpoints
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		api.CommandEndpoint(w, r)
+	})
+	api.handle("/batch", api.BatchEndpoint)
+	api.handle(CommandsPath, api.CommandsEndpoint)
+	api.handle("/openapi.json", api.OpenAPIEndpoint)
+	api.handle("/metrics", api.MetricsEndpoint)
+	api.handle("/healthz", api.HealthEndpoint)
+	api.handle("/readyz", api.ReadyEndpoint)
+	api.handle("/signal", api.SignalEndpoint)
+	api.handle("/stream", api.StreamEndpoint)
+	api.handle("/complete", api.CompleteEndpoint)
+	api.handle(strings.TrimSuffix(UIPath, "/"), api.UIEndpoint)
+	api.handle(UIPath, api.UIEndpoint)
+	return api
 }
 
 func (api *wrapperAPI) Listen(addr, path string) error {
-	if path == "" {
-		path = "/"
+	return api.ListenTLS(addr, path, "", "")
+}
+
+func (api *wrapperAPI) ListenTLS(addr, path, certFile, keyFile string) error {
+	if path != "" {
+		api.handle(path, api.CommandEndpoint)
 	}
-	http.HandleFunc(path, api.CommandEndpoint)
-	return http.ListenAndServe(addr, nil)
+	return api.listenAndServe(addr, certFile, keyFile)
 }
 
 func (api *wrapperAPI) CommandEndpoint(w http.ResponseWriter, r *http.Request) {
-	// Parse command sequence from request body
-	body := []string{}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		handlerErr(w, err, http.StatusBadRequest)
+	if !allowMethod(w, r, http.MethodPost) {
 		return
 	}
+	format, ok := negotiate(r)
+	if !ok {
+		handlerErr(w, ErrNotAcceptable, http.StatusNotAcceptable)
+		return
+	}
+
+	// Parse command sequences from request body
+	limitBody(w, r)
+	reqs, err := readCommands(r)
+	if err != nil {
+		bodyErr(w, err)
+		return
+	}
+	api.command(w, r, reqs, format)
+}
+
+/* command sends the requested command sequences to the wrapped process in order, and
+ * writes their responses in the given format. Commands after one which fails are not sent.
+ */
+func (api *wrapperAPI) command(w http.ResponseWriter, r *http.Request, reqs []commandRequest,
+	format string,
+) {
+	for _, req := range reqs {
+		if modeCommand(req.Command) {
+			handlerErr(w, ErrModeCommand, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Enforce rate limits for the requesting client, refusing all of the commands if any is
+	if limiter := api.h.limiter; limiter != nil {
+		cmds := make([][]string, len(reqs))
+		for i, req := range reqs {
+			cmds[i] = req.Command
+		}
+		if i, wait := allowAll(limiter, requestIdentity(r), cmds); i >= 0 {
+			api.h.metrics.command(reqs[i].Command, "rate_limited")
+			handlerErr(w, &StatusError{StatusRateLimited, "too many commands", wait},
+				http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	// Structured responses require a single command with an Extractor
+	var extract *Extractor
+	if format == FormatStructured {
+		if len(reqs) == 1 {
+			extract = api.extractor(reqs[0].Command)
+		}
+		if extract == nil {
+			handlerErr(w, ErrNoExtractor, http.StatusNotAcceptable)
+			return
+		}
+	}
 
-	// Send command sequence to wrapped process and collect response
-	resp, err := api.c.Send(body...)
+	// Send command sequences to wrapped process and collect responses
+	resp, failed, err := api.send(r.Context(), reqs)
 	if err != nil {
 		if err == ErrCommandForbidden {
-			log.Printf("attempted forbidden command: %v\n", body)
+			log.Printf("attempted forbidden command: %v\n", failed.Command)
+			api.h.metrics.forbid(failed.Command)
+			handlerErr(w, err, http.StatusForbidden)
+			return
 		}
 		handlerErr(w, err, http.StatusInternalServerError)
 		return
 	}
 
 	// Encode response and send back to the client
+	if extract != nil {
+		result, err := extract.Extract(resp)
+		if err != nil {
+			handlerErr(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	if err := writeLines(w, format, resp); err != nil {
+		log.Println(err)
+	}
+}
+
+/* send sends the given command sequences, over one session if there are several, and
+ * returns their concatenated responses. If a command fails, its request is returned with
+ * the error.
+ */
+func (api *wrapperAPI) send(ctx context.Context, reqs []commandRequest) (
+	[]string, commandRequest, error,
+) {
+	if len(reqs) == 1 {
+		req := reqs[0]
+		header := api.c.Parse(req.Command)
+		if header == ForbiddenHeader {
+			return nil, req, ErrCommandForbidden
+		}
+		if h := req.header(); h != "" {
+			header = h
+		}
+		resp, err := api.c.sendHeader(ctx, header, req.Command...)
+		return resp, req, err
+	}
+
+	cmds := make([][]string, len(reqs))
+	headers := make([]string, len(reqs))
+	for i, req := range reqs {
+		cmds[i], headers[i] = req.Command, req.header()
+	}
+	results, err := api.c.sendBatch(ctx, cmds, headers, BatchStopOnError)
+	if err != nil {
+		return nil, commandRequest{}, err
+	}
+	resp := []string{}
+	for i, result := range results {
+		if result.Err != nil {
+			return nil, reqs[i], result.Err
+		}
+		resp = append(resp, result.Lines...)
+	}
+	return resp, commandRequest{}, nil
+}
+
+/* modeCommand reports whether the command sequence switches the connection into another
+ * mode. Arguments are split as the Handler splits them.
+ */
+func modeCommand(args []string) bool {
+	fields := strings.Fields(strings.Join(args, " "))
+	if len(fields) == 0 {
+		return false
+	}
+	switch fields[0] {
+	case SessionCommand, SubscribeCommand, AttachCommand:
+		return true
+	}
+	return false
+}
+
+// extractor returns the Extractor for the given command in the Wrapper's Policy.
+func (api *wrapperAPI) extractor(args []string) *Extractor {
+	api.h.mu.Lock()
+	p := api.h.policy
+	api.h.mu.Unlock()
+	if p == nil {
+		return nil
+	}
+	return p.Commands.MatchExtractor(args)
+}
+
+type batchRequest struct {
+	Commands [][]string `json:"commands"`
+	Continue bool       `json:"continue,omitempty"`
+}
+
+type batchResult struct {
+	Lines  []string `json:"lines"`
+	Error  string   `json:"error,omitempty"`
+	Status string   `json:"status,omitempty"`
+}
+
+func (api *wrapperAPI) BatchEndpoint(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	// Parse command sequences from request body
+	limitBody(w, r)
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		bodyErr(w, err)
+		return
+	}
+	mode := BatchStopOnError
+	if req.Continue {
+		mode = BatchContinue
+	}
+	for _, args := range req.Commands {
+		if modeCommand(args) {
+			handlerErr(w, ErrModeCommand, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Enforce rate limits for the requesting client, for each command
+	results := make([]BatchResult, len(req.Commands))
+	var cmds [][]string
+	var index []int
+	for i, args := range req.Commands {
+		if limiter := api.h.limiter; limiter != nil {
+			if ok, wait := limiter.Allow(requestIdentity(r), args); !ok {
+				api.h.metrics.command(args, "rate_limited")
+				results[i].Err = &StatusError{StatusRateLimited, "too many commands", wait}
+				if mode == BatchStopOnError {
+					skip(results[i+1:], ErrBatchSkipped)
+					break
+				}
+				continue
+			}
+		}
+		cmds, index = append(cmds, args), append(index, i)
+	}
+
+	// Send the allowed commands to the wrapped process and collect the responses
+	if len(cmds) > 0 {
+		sent, err := api.c.SendBatch(r.Context(), cmds, mode)
+		if err != nil {
+			handlerErr(w, err, http.StatusInternalServerError)
+			return
+		}
+		for j, result := range sent {
+			results[index[j]] = result
+		}
+	}
+
+	// Encode the results and send back to the client
+	resp := make([]batchResult, len(results))
+	for i, result := range results {
+		resp[i].Lines = result.Lines
+		if resp[i].Lines == nil {
+			resp[i].Lines = []string{}
+		}
+		if result.Err == nil {
+			continue
+		}
+		resp[i].Error = result.Err.Error()
+		if result.Err == ErrCommandForbidden {
+			log.Printf("attempted forbidden command: %v\n", req.Commands[i])
+			api.h.metrics.forbid(req.Commands[i])
+			resp[i].Status = StatusForbidden
+		}
+		if serr, ok := result.Err.(*StatusError); ok {
+			resp[i].Status = serr.Status
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		handlerErr(w, err, http.StatusInternalServerError)
+		log.Println(err)
+	}
+}
+
+func (api *wrapperAPI) MetricsEndpoint(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := api.h.WriteMetrics(w); err != nil {
+		log.Println(err)
+	}
+}
+
+func (api *wrapperAPI) HealthEndpoint(w http.ResponseWriter, r *http.Request) {
+	health := api.Health()
+	writeHealth(w, health, health.Healthy())
+}
+
+func (api *wrapperAPI) ReadyEndpoint(w http.ResponseWriter, r *http.Request) {
+	health := api.Health()
+	writeHealth(w, health, health.Ready)
+}
+
+func (api *wrapperAPI) SignalEndpoint(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
 		return
 	}
+
+	// Parse signal name from request body
+	limitBody(w, r)
+	var name string
+	if err := json.NewDecoder(r.Body).Decode(&name); err != nil {
+		bodyErr(w, err)
+		return
+	}
+	sig, err := ParseSignal(name)
+	if err != nil {
+		handlerErr(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Signals are authorized by canonical name in the same way as the !signal control command
+	args := []string{ControlPrefix + "signal", signalName(sig)}
+	if !api.h.authorized(args) {
+		log.Printf("attempted forbidden command: %v\n", args)
+		api.h.metrics.forbid(args)
+		handlerErr(w, ErrCommandForbidden, http.StatusForbidden)
+		return
+	}
+	if err := api.Signal(sig); err != nil {
+		status := http.StatusInternalServerError
+		if err == ErrNotRunning {
+			status = http.StatusConflict
+		}
+		handlerErr(w, err, status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *wrapperAPI) StreamEndpoint(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	var kinds []EventKind
+	for _, v := range r.URL.Query()["kind"] {
+		for _, kind := range strings.Split(v, ",") {
+			if kind != "" {
+				kinds = append(kinds, EventKind(kind))
+			}
+		}
+	}
+
+	// Streams are authorized in the same way as the !subscribe control command
+	args := []string{SubscribeCommand}
+	for _, kind := range kinds {
+		args = append(args, string(kind))
+	}
+	if !api.h.authorized(args) {
+		log.Printf("attempted forbidden command: %v\n", args)
+		api.h.metrics.forbid(args)
+		handlerErr(w, ErrCommandForbidden, http.StatusForbidden)
+		return
+	}
+
+	// Streams are not limited by the write timeout of the server
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	events, cancel := api.h.subscribe(kinds...)
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		log.Println(err)
+		return
+	}
+
+	keepAlive := time.NewTicker(StreamKeepAlive)
+	defer keepAlive.Stop()
+	stop := api.stopping()
+	for {
+		var err error
+		select {
+		case e := <-events:
+			var data []byte
+			if data, err = json.Marshal(e); err == nil {
+				_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Kind, data)
+			}
+		case <-keepAlive.C:
+			// Comments keep idle streams open through proxies
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		case <-stop:
+			return
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func writeHealth(w http.ResponseWriter, health Health, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(health); err != nil {
+		log.Println(err)
+	}
 }
 
 func handlerErr(w http.ResponseWriter, err error, status int) {
+	// Status errors reported by the Handler override the given response status
+	if serr, ok := err.(*StatusError); ok {
+		switch serr.Status {
+		case StatusRateLimited:
+			status = http.StatusTooManyRequests
+		case StatusStarting, StatusExited:
+			status = http.StatusServiceUnavailable
+		case StatusForbidden:
+			status = http.StatusForbidden
+		}
+		if serr.RetryAfter > 0 {
+			secs := (serr.RetryAfter + time.Second - 1) / time.Second
+			w.Header().Set("Retry-After", strconv.Itoa(int(secs)))
+		}
+	}
 	// Log the error to the console, set the response header, and send error in response body
 	if err != ErrCommandForbidden {
 		log.Println(err)
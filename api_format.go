@@ -0,0 +1,284 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Maximum size in bytes of the request bodies accepted by the API endpoints.
+var MaxRequestBody int64 = 1 << 20
+
+// Response formats of the API command endpoints, selected by the Accept header.
+const (
+	FormatJSON       = "application/json"
+	FormatStructured = "application/json; structured"
+	FormatText       = "text/plain"
+	FormatNDJSON     = "application/x-ndjson"
+)
+
+var (
+	ErrNoCommand     = errors.New("no command in request")
+	ErrNotAcceptable = errors.New("none of the accepted media types can be produced")
+
+	errUnsupportedType = errors.New("unsupported content type")
+)
+
+/* A commandRequest is a command received by the API, with an optional line count and
+ * timeout overriding the header generated by the parser function.
+ */
+type commandRequest struct {
+	Command []string `json:"command"`
+	Lines   *int     `json:"lines,omitempty"`
+	Timeout *int     `json:"timeout,omitempty"`
+}
+
+// header returns the header for the request, or an empty string to use the parser.
+func (req commandRequest) header() string {
+	if req.Lines == nil && req.Timeout == nil {
+		return ""
+	}
+	lines, timeout := -1, 0
+	if req.Lines != nil {
+		lines = *req.Lines
+	}
+	if req.Timeout != nil {
+		timeout = *req.Timeout
+	}
+	return Header(lines, timeout)
+}
+
+/* newCommandRequest returns the request for a command sequence, which may begin with a
+ * socketcmd header.
+ */
+func newCommandRequest(args []string) commandRequest {
+	if len(args) > 0 {
+		if lines, timeout, err := ParseHeader(args[0]); err == nil {
+			return commandRequest{Command: args[1:], Lines: &lines, Timeout: &timeout}
+		}
+	}
+	return commandRequest{Command: args}
+}
+
+// allowMethod responds with a 405 status unless the request uses one of the given methods.
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+// limitBody limits the size of the request body to MaxRequestBody.
+func limitBody(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxRequestBody)
+}
+
+// bodyErr responds to an error reading the request body.
+func bodyErr(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		status = http.StatusRequestEntityTooLarge
+	} else if errors.Is(err, errUnsupportedType) {
+		status = http.StatusUnsupportedMediaType
+	}
+	handlerErr(w, err, status)
+}
+
+/* readCommands parses the commands in the request body, according to its content type:
+ *
+ *	application/json                   ["list"], ["1:500", "list"] or
+ *	                                   {"command": ["list"], "lines": 1, "timeout": 500}
+ *	text/plain                         one command per line
+ *	application/x-www-form-urlencoded  command=list&lines=1&timeout=500
+ *
+ * Bodies without a content type are parsed as JSON.
+ */
+func readCommands(r *http.Request) ([]commandRequest, error) {
+	mediaType := ""
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(ct); err != nil {
+			return nil, err
+		}
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := bytes.TrimSpace(body)
+	// Tools such as curl send JSON bodies as forms unless told otherwise
+	if mediaType == "application/x-www-form-urlencoded" && len(trimmed) > 0 &&
+		(trimmed[0] == '[' || trimmed[0] == '{') {
+		mediaType = "application/json"
+	}
+
+	var reqs []commandRequest
+	switch mediaType {
+	case "text/plain":
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			if args := strings.Fields(scanner.Text()); len(args) > 0 {
+				reqs = append(reqs, newCommandRequest(args))
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	case "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+		var base commandRequest
+		for _, field := range []struct {
+			name string
+			dst  **int
+		}{{"lines", &base.Lines}, {"timeout", &base.Timeout}} {
+			if v := form.Get(field.name); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					return nil, errors.New("invalid " + field.name + ": " + v)
+				}
+				*field.dst = &n
+			}
+		}
+		for _, cmd := range form["command"] {
+			if args := strings.Fields(cmd); len(args) > 0 {
+				req := base
+				req.Command = args
+				reqs = append(reqs, req)
+			}
+		}
+	case "", "application/json":
+		// A command object, or a command sequence with an optional header
+		if len(trimmed) > 0 && trimmed[0] == '{' {
+			var req commandRequest
+			if err := json.Unmarshal(trimmed, &req); err != nil {
+				return nil, err
+			}
+			reqs = append(reqs, req)
+		} else {
+			args := []string{}
+			if err := json.Unmarshal(body, &args); err != nil {
+				return nil, err
+			}
+			reqs = append(reqs, newCommandRequest(args))
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedType, mediaType)
+	}
+	for _, req := range reqs {
+		if len(req.Command) == 0 {
+			return nil, ErrNoCommand
+		}
+	}
+	if len(reqs) == 0 {
+		return nil, ErrNoCommand
+	}
+	return reqs, nil
+}
+
+/* negotiate selects the response format for the request's Accept header, preferring the
+ * media type with the highest quality. JSON is used if the request has no Accept header.
+ */
+func negotiate(r *http.Request) (string, bool) {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return FormatJSON, true
+	}
+	format, best := "", 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		q, structured := 1.0, false
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			switch {
+			case strings.HasPrefix(param, "q="):
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			case param == "structured" || strings.HasPrefix(param, "structured="):
+				structured = true
+			}
+		}
+
+		var f string
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case "application/json":
+			f = FormatJSON
+			if structured {
+				f = FormatStructured
+			}
+		case "text/plain", "text/*":
+			f = FormatText
+		case "application/x-ndjson", "application/ndjson":
+			f = FormatNDJSON
+		case "*/*", "application/*":
+			f = FormatJSON
+		}
+		if f != "" && q > best {
+			format, best = f, q
+		}
+	}
+	return format, format != ""
+}
+
+// writeLines writes the response lines in the given format.
+func writeLines(w http.ResponseWriter, format string, lines []string) error {
+	switch format {
+	case FormatText:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, line := range lines {
+			if _, err := io.WriteString(w, line+"\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatNDJSON:
+		w.Header().Set("Content-Type", FormatNDJSON)
+		enc := json.NewEncoder(w)
+		for _, line := range lines {
+			if err := enc.Encode(line); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if lines == nil {
+		lines = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(lines)
+}
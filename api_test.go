@@ -0,0 +1,134 @@
+package socketcmd_test
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+	"github.com/faceless-saint/go-socketcmd/socketcmdtest"
+
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveAPI serves the WrapperAPI of the Wrapper, with commands waiting up to 10s for a line.
+func serveAPI(t *testing.T, w socketcmd.Wrapper) *httptest.Server {
+	srv := httptest.NewServer(w.ExposeAPI(func([]string) string { return "1:10000" }))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func post(t *testing.T, ctx context.Context, url, body string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func TestCommandEndpointModeCommands(t *testing.T) {
+	w, _ := newWrapper(t, socketcmdtest.NewScript())
+	srv := serveAPI(t, w)
+
+	// Commands which would turn the request into a streaming connection are refused
+	for _, cmd := range []string{`"!session"`, `"!subscribe", "stdout"`, `"!attach alice"`} {
+		for path, body := range map[string]string{
+			"/":      `{"command": [` + cmd + `]}`,
+			"/batch": `{"commands": [["ping"], [` + cmd + `]]}`,
+		} {
+			code, err := post(t, context.Background(), srv.URL+path, body)
+			if err != nil {
+				t.Fatalf("POST %s %s: %v", path, cmd, err)
+			}
+			if code != http.StatusBadRequest {
+				t.Errorf("POST %s %s: status %d, want %d", path, cmd, code, http.StatusBadRequest)
+			}
+		}
+	}
+}
+
+func TestCommandEndpointContext(t *testing.T) {
+	s := socketcmdtest.NewScript().OnDelay("slow", 5*time.Second, "done")
+	w, _ := newWrapper(t, s)
+	srv := serveAPI(t, w)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := post(t, ctx, srv.URL+"/", `{"command": ["slow"]}`); err == nil {
+		t.Fatal("POST slow succeeded before the response")
+	}
+	// The exchange ends with the request, so the server can shut down
+	closed := make(chan struct{})
+	go func() {
+		srv.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("the request was still active after its client went away")
+	}
+}
+
+func TestSignalEndpointPolicy(t *testing.T) {
+	w, _ := newWrapper(t, socketcmdtest.NewScript(), signalPolicy)
+	srv := serveAPI(t, w)
+	ctx := contextTimeout(t, 5*time.Second)
+
+	// The policy applies to every form of the signal name
+	for _, sig := range []string{"KILL", "kill", "SIGKILL", "sigkill", "9"} {
+		if status, err := post(t, ctx, srv.URL+"/signal", `"`+sig+`"`); err != nil || status != http.StatusForbidden {
+			t.Fatalf("POST /signal %s = %d, %v; want %d", sig, status, err, http.StatusForbidden)
+		}
+	}
+	if state := w.State(); state != socketcmd.StateRunning {
+		t.Fatalf("state = %s after forbidden signals, want %s", state, socketcmd.StateRunning)
+	}
+}
+
+func TestCommandEndpointSequence(t *testing.T) {
+	s := socketcmdtest.NewScript().On("ping", "pong")
+	w, _ := newWrapper(t, s)
+	srv := serveAPI(t, w)
+
+	// Several commands are sent over an in-process session, without !session in the Policy
+	resp, err := http.Post(srv.URL+"/", "text/plain", strings.NewReader("ping\nping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var lines []string
+	if err := json.NewDecoder(resp.Body).Decode(&lines); err != nil {
+		t.Fatalf("status %d: %v", resp.StatusCode, err)
+	}
+	if want := []string{"pong", "pong"}; !reflect.DeepEqual(lines, want) {
+		t.Fatalf("response %q, want %q", lines, want)
+	}
+}
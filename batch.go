@@ -0,0 +1,163 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// BatchMode determines how SendBatch handles commands which fail.
+type BatchMode int
+
+const (
+	// Stop at the first command which fails, skipping the commands after it
+	BatchStopOnError BatchMode = iota
+	// Send every command, pipelining them over the session
+	BatchContinue
+)
+
+var ErrBatchSkipped = errors.New("command skipped after an earlier error")
+
+// A BatchResult is the response to one command of a batch.
+type BatchResult struct {
+	Lines []string
+	Err   error
+}
+
+/* SendBatch sends the given commands in order over one session, with headers generated by
+ * the Client's parser function, and returns the result of each command. In BatchContinue
+ * mode the commands are pipelined, so the response to each command is read while the
+ * commands after it are sent. An error is returned if the session cannot be opened.
+ */
+func (c *client) SendBatch(ctx context.Context, cmds [][]string, mode BatchMode) (
+	[]BatchResult, error,
+) {
+	return c.sendBatch(ctx, cmds, nil, mode)
+}
+
+/* sendBatch sends a batch of commands with the given headers, using the Client's parser
+ * function for commands without a header.
+ */
+func (c *client) sendBatch(ctx context.Context, cmds [][]string, headers []string, mode BatchMode) (
+	[]BatchResult, error,
+) {
+	// Borrow a session from the pool, or open one for the batch
+	var s *session
+	var err error
+	if c.pool != nil {
+		if s, err = c.pool.get(ctx, c.openSession); err != nil {
+			return nil, err
+		}
+		defer c.pool.put(s)
+	} else {
+		if s, err = c.openSession(ctx); err != nil {
+			return nil, err
+		}
+		defer s.conn.Close()
+	}
+	defer s.watch(ctx)()
+
+	// Commands which are forbidden or malformed are not sent
+	results := make([]BatchResult, len(cmds))
+	msgs := make([]string, len(cmds))
+	for i, args := range cmds {
+		header := c.Parse(args)
+		if header == ForbiddenHeader {
+			results[i].Err = ErrCommandForbidden
+			continue
+		}
+		if i < len(headers) && headers[i] != "" {
+			header = headers[i]
+		}
+		msgs[i], results[i].Err = message(header, args)
+	}
+
+	if mode == BatchContinue {
+		s.pipeline(msgs, results)
+	} else {
+		for i, msg := range msgs {
+			if results[i].Err == nil {
+				if results[i].Err = s.write(msg); results[i].Err == nil {
+					results[i].Lines, results[i].Err = s.read()
+				}
+			}
+			if results[i].Err != nil {
+				skip(results[i+1:], ErrBatchSkipped)
+				break
+			}
+		}
+	}
+	if s.broken && ctx.Err() != nil {
+		for i := range results {
+			if results[i].Err != nil && results[i].Err != ErrBatchSkipped {
+				results[i].Err = ctxErr(ctx, results[i].Err)
+			}
+		}
+	}
+	return results, nil
+}
+
+/* pipeline writes the given messages while reading their responses into the results.
+ * Messages with an error result are not sent. If the session breaks, the commands without a
+ * response are given the error.
+ */
+func (s *session) pipeline(msgs []string, results []BatchResult) {
+	var send []string
+	for i, msg := range msgs {
+		if results[i].Err == nil {
+			send = append(send, msg)
+		}
+	}
+	written := make(chan error, 1)
+	go func() {
+		for _, msg := range send {
+			if _, err := io.WriteString(s.conn, msg); err != nil {
+				written <- err
+				return
+			}
+		}
+		written <- nil
+	}()
+
+	for i := range msgs {
+		if results[i].Err != nil {
+			continue
+		}
+		lines, err := s.read()
+		results[i].Lines, results[i].Err = lines, err
+		if s.broken {
+			skip(results[i+1:], err)
+			break
+		}
+	}
+	if err := <-written; err != nil {
+		s.broken = true
+	}
+}
+
+// skip sets the error of the results which have not failed.
+func skip(results []BatchResult, err error) {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Err = err
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package socketcmd_test
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+	"github.com/faceless-saint/go-socketcmd/socketcmdtest"
+
+	"context"
+	"reflect"
+	"testing"
+)
+
+// batchPolicy forbids the stop command, so that it fails without being sent.
+var batchPolicy = &socketcmd.Argument{
+	Header: "1:",
+	Args:   map[string]socketcmd.Argument{"stop": {Header: socketcmd.ForbiddenHeader}},
+}
+
+func batchScript() *socketcmdtest.Script {
+	return socketcmdtest.NewScript().OnMatch(`^echo (.*)$`, 0, "$1")
+}
+
+var batch = [][]string{{"echo", "one"}, {"stop"}, {"echo", "two"}}
+
+func TestBatchStopOnError(t *testing.T) {
+	s := batchScript()
+	_, c := newWrapper(t, s, allowControl(socketcmd.SessionCommand))
+	c.Policy(batchPolicy)
+
+	results, err := c.SendBatch(context.Background(), batch, socketcmd.BatchStopOnError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, results[0].Lines, results[0].Err, "one")
+	if results[1].Err != socketcmd.ErrCommandForbidden {
+		t.Errorf("forbidden command: error %v, want %v", results[1].Err, socketcmd.ErrCommandForbidden)
+	}
+	if results[2].Err != socketcmd.ErrBatchSkipped {
+		t.Errorf("command after the error: error %v, want %v", results[2].Err, socketcmd.ErrBatchSkipped)
+	}
+	if got, want := s.Current().Inputs(), []string{"echo one"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Inputs() = %q, want %q", got, want)
+	}
+}
+
+func TestBatchContinue(t *testing.T) {
+	s := batchScript()
+	_, c := newWrapper(t, s, allowControl(socketcmd.SessionCommand))
+	c.Policy(batchPolicy)
+
+	// The commands are pipelined, and each response is matched to its command
+	cmds := append(append([][]string(nil), batch...), []string{"echo", "three"}, []string{"echo", "four"})
+	results, err := c.SendBatch(context.Background(), cmds, socketcmd.BatchContinue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[1].Err != socketcmd.ErrCommandForbidden {
+		t.Errorf("forbidden command: error %v, want %v", results[1].Err, socketcmd.ErrCommandForbidden)
+	}
+	for i, want := range map[int]string{0: "one", 2: "two", 3: "three", 4: "four"} {
+		if r := results[i]; r.Err != nil || !reflect.DeepEqual(r.Lines, []string{want}) {
+			t.Errorf("result %d: %q, %v, want %q", i, r.Lines, r.Err, want)
+		}
+	}
+	want := []string{"echo one", "echo two", "echo three", "echo four"}
+	if got := s.Current().Inputs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Inputs() = %q, want %q", got, want)
+	}
+}
+
+func TestBatchPool(t *testing.T) {
+	_, c := newWrapper(t, batchScript(), allowControl(socketcmd.SessionCommand))
+	c.Policy(batchPolicy)
+	c.Pool(socketcmd.Pool{MaxOpen: 1})
+	defer c.Close()
+
+	// Batches borrow the pooled session in turn
+	for i := 0; i < 3; i++ {
+		results, err := c.SendBatch(context.Background(), batch, socketcmd.BatchContinue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect(t, results[2].Lines, results[2].Err, "two")
+	}
+}
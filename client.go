@@ -21,9 +21,11 @@ package socketcmd
 import (
 	"bufio"
 	"context"
+	"errors"
 	"io"
 	"net"
 	"strings"
+	"time"
 )
 
 // A Client connects to a Wrapper's socket.
@@ -32,7 +34,8 @@ type Client interface {
 	 */
 	Dialer(net.Dialer)
 	/* Send the given arguments to the socket Wrapper. The Client's parser function is used
-	 * to generate the socketcmd header appropriate for the given arguments.
+	 * to generate the socketcmd header appropriate for the given arguments. If the Wrapper
+	 * refuses the command, a *StatusError describing the reason is returned.
 	 */
 	Send(args ...string) ([]string, error)
 	/* SendContext sends the given arguments to the socket Wrapper, using the given context
@@ -40,6 +43,75 @@ type Client interface {
 	 * socketcmd header appropriate for the given arguments.
 	 */
 	SendContext(ctx context.Context, args ...string) ([]string, error)
+	/* SendBatch sends several commands over one session, with a result for each command.
+	 * The mode determines whether the batch stops at the first command which fails.
+	 */
+	SendBatch(ctx context.Context, cmds [][]string, mode BatchMode) ([]BatchResult, error)
+	/* SendStructured sends the given arguments to the socket Wrapper, and returns the fields
+	 * extracted from the response by the Extractor defined for the command in the Client's
+	 * policy.
+	 */
+	SendStructured(ctx context.Context, args ...string) (map[string]interface{}, error)
+	/* Target returns a copy of the Client which sends commands to the named instance of a
+	 * Multiplexer.
+	 */
+	Target(name string) Client
+	/* Pool configures the Client to send commands over pooled sessions, instead of dialing
+	 * a new connection for each command.
+	 */
+	Pool(Pool)
+	/* Session opens a new Session with the socket Wrapper, which is not shared with other
+	 * callers and must be closed when no longer needed.
+	 */
+	Session(ctx context.Context) (Session, error)
+	/* Subscribe streams the events of the given kinds (or every kind) from the socket
+	 * Wrapper, such as the output of the wrapped process, until the context is done.
+	 */
+	Subscribe(ctx context.Context, kinds ...EventKind) (<-chan Event, error)
+	/* Attach attaches to the wrapped process as the named operator (or as the identity of
+	 * the connection, if the name is empty), until the context is done or the Attachment
+	 * is closed.
+	 */
+	Attach(ctx context.Context, name string) (Attachment, error)
+	// Close the idle pooled sessions of the Client.
+	Close() error
+	// Retry configures how the Client retries failed commands.
+	Retry(Retry)
+	/* Endpoints sets the socket addresses tried by the Client, in place of the address it
+	 * was created with.
+	 */
+	Endpoints(Balance, ...Endpoint)
+	/* Policy sets the command tree used by the Client to generate headers (replacing its
+	 * parser function) and to determine which commands are idempotent.
+	 */
+	Policy(*Argument)
+
+	/* Control command helpers. These bypass the Client's parser function, leaving
+	 * authorization to the Wrapper's Policy.
+	 */
+
+	// Health returns the health of the wrapped process (!health).
+	Health() (Health, error)
+	// Status returns the status of the wrapped process (!status).
+	Status() (ProcessStatus, error)
+	// PID returns the process ID of the wrapped process (!pid).
+	PID() (int, error)
+	// Uptime returns the time since the wrapped process was started (!uptime).
+	Uptime() (time.Duration, error)
+	/* Complete returns the completions of the last of the given arguments which the
+	 * Wrapper's Policy allows (!complete). An empty last argument lists every subcommand.
+	 */
+	Complete(args ...string) ([]Candidate, error)
+	// History returns the most recent commands sent to the wrapped process (!history).
+	History() ([]string, error)
+	// Restart the wrapped process (!restart).
+	Restart() error
+	// Stop the wrapped process (!stop).
+	Stop() error
+	// Signal sends the named signal to the wrapped process (!signal).
+	Signal(sig string) error
+	// ReloadPolicy reloads the Wrapper's policy file (!reload-policy).
+	ReloadPolicy() error
 }
 
 // NewClient returns a new Client for the given socket address and parser.
@@ -47,33 +119,46 @@ func NewClient(proto, addr string, parser ParseFunc) Client {
 	if parser == nil {
 		parser = DefaultParseFunc
 	}
-	return &client{parser, proto, addr, net.Dialer{}}
+	return &client{Parse: parser, Protocol: proto, Address: addr}
 }
 
 type client struct {
 	Parse    ParseFunc
 	Protocol string
 	Address  string
+	Instance string
 
-	d net.Dialer
+	d    net.Dialer
+	dial func(context.Context) (net.Conn, error)
+	pool *pool
+
+	retry     *Retry
+	policy    *Argument
+	endpoints []Endpoint
+	balance   Balance
+	next      uint32
 }
 
 func (c *client) Dialer(dialer net.Dialer) {
 	c.d = dialer
 }
 
-func (c *client) Send(args ...string) ([]string, error) {
-	header := c.Parse(args)
-	if header == ForbiddenHeader {
-		return nil, ErrCommandForbidden
+func (c *client) Pool(config Pool) {
+	if c.pool != nil {
+		c.pool.close()
 	}
+	c.pool = newPool(config)
+}
 
-	conn, err := c.d.Dial(c.Protocol, c.Address)
-	if err != nil {
-		return nil, err
+func (c *client) Close() error {
+	if c.pool == nil {
+		return nil
 	}
-	defer conn.Close()
-	return c.send(conn, header, args...)
+	return c.pool.close()
+}
+
+func (c *client) Send(args ...string) ([]string, error) {
+	return c.SendContext(context.Background(), args...)
 }
 
 func (c *client) SendContext(ctx context.Context, args ...string) ([]string, error) {
@@ -81,13 +166,65 @@ func (c *client) SendContext(ctx context.Context, args ...string) ([]string, err
 	if header == ForbiddenHeader {
 		return nil, ErrCommandForbidden
 	}
+	return c.sendHeader(ctx, header, args...)
+}
+
+// sendHeader sends the given arguments to the socket Wrapper with the given header.
+func (c *client) sendHeader(ctx context.Context, header string, args ...string) ([]string, error) {
+	return c.withRetry(ctx, args, func(ctx context.Context) ([]string, bool, error) {
+		if c.pool != nil {
+			return c.sendPooled(ctx, header, args...)
+		}
+		conn, err := c.dialContext(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		defer conn.Close()
+		// The connection is interrupted when the context is done
+		s := &session{conn: conn}
+		defer s.watch(ctx)()
+		lines, err := c.send(conn, header, args...)
+		if err != nil {
+			err = ctxErr(ctx, err)
+		}
+		return lines, true, err
+	})
+}
+
+func (c *client) Target(name string) Client {
+	target := *c
+	target.Instance = name
+	// Sessions are bound to the instance they were opened for
+	if c.pool != nil {
+		target.pool = newPool(c.pool.config)
+	}
+	return &target
+}
 
-	conn, err := c.d.DialContext(ctx, c.Protocol, c.Address)
+/* sendPooled sends a command over a session borrowed from the pool, reporting whether the
+ * command may have been sent.
+ */
+func (c *client) sendPooled(ctx context.Context, header string, args ...string) (
+	[]string, bool, error,
+) {
+	s, err := c.pool.get(ctx, c.openSession)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	defer conn.Close()
-	return c.send(conn, header, args...)
+	defer c.pool.put(s)
+	lines, err := s.exchange(ctx, header, args...)
+	return lines, !errors.Is(err, ErrNewline), err
+}
+
+// dialContext opens a new connection to the socket.
+func (c *client) dialContext(ctx context.Context) (net.Conn, error) {
+	if c.dial != nil {
+		return c.dial(ctx)
+	}
+	if len(c.endpoints) > 0 {
+		return c.dialEndpoints(ctx)
+	}
+	return c.d.DialContext(ctx, c.Protocol, c.Address)
 }
 
 func (c *client) send(conn net.Conn, header string, args ...string) ([]string, error) {
@@ -102,13 +239,17 @@ func (c *client) send(conn net.Conn, header string, args ...string) ([]string, e
 	for scanner.Scan() {
 		results = append(results, scanner.Text())
 	}
-	return results, scanner.Err()
+	if err := scanner.Err(); err != nil {
+		return results, err
+	}
+	return splitStatus(results)
 }
 
 func (c *client) stream(conn net.Conn, header string, args ...string) (
 	*bufio.Scanner, error,
 ) {
 	// Send the given arguments to the socket as a space-separated string
+	header = TargetHeader(c.Instance, header)
 	if _, err := io.WriteString(conn, header+" "+strings.Join(args, " ")); err != nil {
 		return nil, err
 	}
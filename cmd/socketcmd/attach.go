@@ -0,0 +1,155 @@
+package main
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// Default key sequence which detaches from the wrapped process
+var DefaultDetachKeys = "ctrl-]"
+
+/* socketcmd attach [options]
+ *
+ * Attaches the terminal to the wrapped process through the socket as an operator, like the
+ * terminal of the Wrapper itself: the output of the process and the input of other operators
+ * are streamed live, and each line typed is sent to the process as input. Typing the detach
+ * keys (or Ctrl-D on an empty line) detaches, leaving the process running.
+ */
+func attachCommand(args []string) int {
+	var cf clientFlags
+	fs := flagSet("attach", "")
+	cf.register(fs)
+	name := fs.String("name", os.Getenv("USER"), "operator name shown to other operators")
+	historyPath := fs.String("history", defaultHistoryPath(),
+		"file to record command history in (empty to disable)")
+	detachKeys := fs.String("detach-keys", DefaultDetachKeys,
+		"key sequence which detaches from the process, e.g. ctrl-p,ctrl-q")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+	detach, err := parseKeys(*detachKeys)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "socketcmd:", err)
+		return ExitUsage
+	}
+
+	c, err := cf.client("")
+	if err != nil {
+		return fail(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM,
+		syscall.SIGHUP)
+	defer stop()
+	a, err := c.Attach(ctx, *name)
+	if err != nil {
+		return fail(err)
+	}
+	defer a.Close()
+	hist, err := loadHistory(*historyPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "socketcmd:", err)
+	}
+
+	lr := newLineReader("", hist, detach, completeFunc(c))
+	defer lr.Close()
+	fmt.Fprintf(os.Stderr, "attached to %s, detach with %s, take the write lock with %s\r\n",
+		cf.socket, *detachKeys, socketcmd.LockCommand)
+
+	// Print the live output until the attachment ends
+	lost := make(chan struct{})
+	go func() {
+		defer close(lost)
+		for e := range a.Events() {
+			lr.Print(e.String())
+		}
+	}()
+
+	// Send each line typed to the process
+	type read struct {
+		line string
+		err  error
+	}
+	lines := make(chan read)
+	go func() {
+		for {
+			line, err := lr.ReadLine()
+			lines <- read{line, err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case r := <-lines:
+			if r.err == ErrDetach || r.err == io.EOF {
+				fmt.Fprintln(os.Stderr, "detached")
+				return ExitOK
+			} else if r.err != nil {
+				return fail(r.err)
+			}
+			if err := a.Input(r.line); err != nil {
+				lr.Print("socketcmd: " + err.Error())
+			}
+		case <-lost:
+			if ctx.Err() != nil {
+				return ExitOK
+			}
+			lr.Close()
+			return fail(errors.New("connection to the Wrapper was closed"))
+		case <-ctx.Done():
+			return ExitOK
+		}
+	}
+}
+
+/* completeFunc returns a completer which completes commands from the Wrapper's Policy,
+ * through the !complete control command.
+ */
+func completeFunc(c socketcmd.Client) completer {
+	return func(line string) []string {
+		args := strings.Fields(line)
+		if len(args) == 0 || strings.HasSuffix(line, " ") {
+			args = append(args, "")
+		}
+		candidates, err := c.Complete(args...)
+		if err != nil {
+			return nil
+		}
+		names := make([]string, len(candidates))
+		for i, candidate := range candidates {
+			names[i] = candidate.Name
+		}
+		return names
+	}
+}
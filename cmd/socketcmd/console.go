@@ -0,0 +1,188 @@
+package main
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Prompt shown by the console when reading from a terminal
+var Prompt = "> "
+
+// Maximum number of commands kept in the console history file
+var HistorySize = 1000
+
+/* socketcmd console [options]
+ *
+ * Sends each line read from stdin as a command over one session, printing the responses.
+ * On a terminal, lines are edited with history (Up/Down) and completion (Tab) from the
+ * Wrapper's Policy. Commands are recorded in the history file.
+ */
+func consoleCommand(args []string) int {
+	var cf clientFlags
+	fs := flagSet("console", "")
+	cf.register(fs)
+	header := fs.String("header", "", "socketcmd header for every command (default from the "+
+		"policy, or "+socketcmd.DefaultHeader+")")
+	historyPath := fs.String("history", defaultHistoryPath(),
+		"file to record command history in (empty to disable)")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	c, err := cf.client(*header)
+	if err != nil {
+		return fail(err)
+	}
+	hist, err := loadHistory(*historyPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "socketcmd:", err)
+	}
+	lr := newLineReader(Prompt, hist, nil, completeFunc(c))
+	defer lr.Close()
+
+	var s socketcmd.Session
+	defer func() {
+		if s != nil {
+			s.Close()
+		}
+	}()
+	code := ExitOK
+	for {
+		line, err := lr.ReadLine()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fail(err)
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+
+		// The session is opened on first use, and reopened if the connection is lost
+		if s == nil {
+			if s, err = c.Session(context.Background()); err != nil {
+				if rc := report(lr, err); code == ExitOK {
+					code = rc
+				}
+				continue
+			}
+		}
+		lines, err := s.Send(strings.Fields(line)...)
+		for _, l := range lines {
+			lr.Print(l)
+		}
+		if err != nil {
+			if rc := report(lr, err); code == ExitOK {
+				code = rc
+			}
+			var serr *socketcmd.StatusError
+			if !errors.As(err, &serr) && err != socketcmd.ErrCommandForbidden {
+				s.Close()
+				s = nil
+			}
+		}
+	}
+	if isTerminal(os.Stdin) {
+		return ExitOK
+	}
+	return code
+}
+
+// report prints the given error through the line reader and returns its exit code.
+func report(lr lineReader, err error) int {
+	lr.Print("socketcmd: " + err.Error())
+	return exitCode(err)
+}
+
+// isTerminal reports whether the given file is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func defaultHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".socketcmd_history")
+}
+
+// A history records the commands entered in the console, in memory and in a file.
+type history struct {
+	path  string
+	lines []string
+}
+
+// loadHistory reads the most recent HistorySize commands from the given file, if it exists.
+func loadHistory(path string) (*history, error) {
+	h := &history{path: path}
+	if path == "" {
+		return h, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	} else if err != nil {
+		return h, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		h.lines = append(h.lines, scanner.Text())
+	}
+	if len(h.lines) > HistorySize {
+		h.lines = h.lines[len(h.lines)-HistorySize:]
+	}
+	return h, scanner.Err()
+}
+
+// add records a command, unless it is blank or repeats the previous command.
+func (h *history) add(line string) {
+	if line = strings.TrimSpace(line); line == "" {
+		return
+	}
+	if len(h.lines) > 0 && h.lines[len(h.lines)-1] == line {
+		return
+	}
+	h.lines = append(h.lines, line)
+	if h.path == "" {
+		return
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
@@ -0,0 +1,428 @@
+package main
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrDetach is returned by a lineReader when the user types the detach key sequence.
+var ErrDetach = errors.New("detached")
+
+/* A lineReader reads the lines typed by the user, and prints output without disturbing the
+ * line being typed.
+ */
+type lineReader interface {
+	/* ReadLine returns the next line, io.EOF at the end of input, or ErrDetach. Lines which
+	 * are not blank are added to the history.
+	 */
+	ReadLine() (string, error)
+	// Print a line of output above the line being typed.
+	Print(line string)
+	// Close restores the terminal.
+	Close() error
+}
+
+/* A completer returns the completions of the last word of the given line, or of the word
+ * after it if the line ends with a space.
+ */
+type completer func(line string) []string
+
+/* newLineReader returns a line editor for the terminal on stdin, with the given prompt,
+ * history, detach key sequence and completer. If stdin is not a terminal, or raw mode is not
+ * supported, lines are read as they are without editing.
+ */
+func newLineReader(prompt string, hist *history, detach []byte, complete completer) lineReader {
+	if isTerminal(os.Stdin) {
+		if restore, err := makeRaw(os.Stdin); err == nil {
+			return &editor{
+				in:       bufio.NewReader(os.Stdin),
+				out:      os.Stdout,
+				restore:  restore,
+				prompt:   prompt,
+				hist:     hist,
+				detach:   detach,
+				complete: complete,
+			}
+		}
+		return &plainReader{scanner: bufio.NewScanner(os.Stdin), prompt: prompt, hist: hist}
+	}
+	return &plainReader{scanner: bufio.NewScanner(os.Stdin), hist: hist}
+}
+
+// A plainReader reads lines without editing, showing the prompt if there is one.
+type plainReader struct {
+	scanner *bufio.Scanner
+	prompt  string
+	hist    *history
+}
+
+func (r *plainReader) ReadLine() (string, error) {
+	fmt.Fprint(os.Stderr, r.prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		if r.prompt != "" {
+			fmt.Fprintln(os.Stderr)
+		}
+		return "", io.EOF
+	}
+	r.hist.add(r.scanner.Text())
+	return r.scanner.Text(), nil
+}
+
+func (r *plainReader) Print(line string) {
+	fmt.Println(line)
+}
+
+func (r *plainReader) Close() error {
+	return nil
+}
+
+// Control keys recognized by the editor
+const (
+	keyCtrlA     = 0x01
+	keyCtrlB     = 0x02
+	keyCtrlC     = 0x03
+	keyCtrlD     = 0x04
+	keyCtrlE     = 0x05
+	keyCtrlF     = 0x06
+	keyCtrlH     = 0x08
+	keyTab       = 0x09
+	keyLF        = 0x0a
+	keyCtrlK     = 0x0b
+	keyCtrlL     = 0x0c
+	keyCR        = 0x0d
+	keyCtrlN     = 0x0e
+	keyCtrlP     = 0x10
+	keyCtrlU     = 0x15
+	keyCtrlW     = 0x17
+	keyEscape    = 0x1b
+	keyBackspace = 0x7f
+)
+
+/* An editor reads lines from a terminal in raw mode, with cursor movement, history and
+ * completion. Lines of output are printed above the line being edited, which is redrawn.
+ */
+type editor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	restore  func() error
+	prompt   string
+	hist     *history
+	detach   []byte
+	complete completer
+
+	mu      sync.Mutex
+	reading bool
+	buf     []rune
+	pos     int
+	// Position in the history while browsing it, and the line edited before browsing
+	histPos int
+	saved   []rune
+}
+
+func (e *editor) ReadLine() (string, error) {
+	e.mu.Lock()
+	e.reading, e.buf, e.pos, e.histPos = true, nil, 0, len(e.hist.lines)
+	e.redraw()
+	e.mu.Unlock()
+
+	matched := 0
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		// Keys which may be the start of the detach sequence are held until it is complete
+		if matched < len(e.detach) && r == rune(e.detach[matched]) {
+			if matched++; matched == len(e.detach) {
+				e.end()
+				return "", ErrDetach
+			}
+			continue
+		}
+		keys := make([]rune, 0, matched+1)
+		for _, k := range e.detach[:matched] {
+			keys = append(keys, rune(k))
+		}
+		matched = 0
+		for _, k := range append(keys, r) {
+			if line, done, err := e.key(k); done || err != nil {
+				return line, err
+			}
+		}
+	}
+}
+
+// key handles a key typed by the user, reporting whether the line is complete.
+func (e *editor) key(r rune) (string, bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch r {
+	case keyCR, keyLF:
+		line := string(e.buf)
+		e.pos = len(e.buf)
+		e.redraw()
+		io.WriteString(e.out, "\n")
+		e.reading = false
+		e.hist.add(line)
+		return line, true, nil
+	case keyCtrlD:
+		if len(e.buf) == 0 {
+			io.WriteString(e.out, "\n")
+			e.reading = false
+			return "", true, io.EOF
+		}
+		e.delete(e.pos, e.pos+1)
+	case keyCtrlC:
+		// Abandon the line, as a shell does
+		io.WriteString(e.out, "^C\n")
+		e.buf, e.pos, e.histPos = nil, 0, len(e.hist.lines)
+	case keyBackspace, keyCtrlH:
+		e.delete(e.pos-1, e.pos)
+	case keyCtrlA:
+		e.pos = 0
+	case keyCtrlE:
+		e.pos = len(e.buf)
+	case keyCtrlB:
+		e.move(-1)
+	case keyCtrlF:
+		e.move(1)
+	case keyCtrlK:
+		e.delete(e.pos, len(e.buf))
+	case keyCtrlU:
+		e.delete(0, e.pos)
+	case keyCtrlW:
+		start := e.pos
+		for start > 0 && e.buf[start-1] == ' ' {
+			start--
+		}
+		for start > 0 && e.buf[start-1] != ' ' {
+			start--
+		}
+		e.delete(start, e.pos)
+	case keyCtrlL:
+		io.WriteString(e.out, "\x1b[H\x1b[2J")
+	case keyCtrlP:
+		e.browse(-1)
+	case keyCtrlN:
+		e.browse(1)
+	case keyTab:
+		e.completeWord()
+	case keyEscape:
+		e.escape()
+	default:
+		if r >= ' ' {
+			e.buf = append(e.buf[:e.pos], append([]rune{r}, e.buf[e.pos:]...)...)
+			e.pos++
+		}
+	}
+	e.redraw()
+	return "", false, nil
+}
+
+// escape handles the escape sequences sent by the cursor and editing keys.
+func (e *editor) escape() {
+	intro, err := e.in.ReadByte()
+	if err != nil || (intro != '[' && intro != 'O') {
+		return
+	}
+	var param []byte
+	for {
+		b, err := e.in.ReadByte()
+		if err != nil {
+			return
+		}
+		if b >= 0x40 && b <= 0x7e {
+			e.sequence(string(param), b)
+			return
+		}
+		param = append(param, b)
+	}
+}
+
+func (e *editor) sequence(param string, final byte) {
+	switch final {
+	case 'A':
+		e.browse(-1)
+	case 'B':
+		e.browse(1)
+	case 'C':
+		e.move(1)
+	case 'D':
+		e.move(-1)
+	case 'H':
+		e.pos = 0
+	case 'F':
+		e.pos = len(e.buf)
+	case '~':
+		switch param {
+		case "1", "7":
+			e.pos = 0
+		case "4", "8":
+			e.pos = len(e.buf)
+		case "3":
+			e.delete(e.pos, e.pos+1)
+		}
+	}
+}
+
+func (e *editor) move(n int) {
+	if p := e.pos + n; p >= 0 && p <= len(e.buf) {
+		e.pos = p
+	}
+}
+
+// delete removes the runes of the line between the given positions.
+func (e *editor) delete(from, to int) {
+	if from < 0 {
+		from = 0
+	}
+	if to > len(e.buf) {
+		to = len(e.buf)
+	}
+	if from >= to {
+		return
+	}
+	e.buf = append(e.buf[:from], e.buf[to:]...)
+	e.pos = from
+}
+
+// browse replaces the line with an earlier (-1) or later (1) line of the history.
+func (e *editor) browse(n int) {
+	p := e.histPos + n
+	if p < 0 || p > len(e.hist.lines) {
+		return
+	}
+	if e.histPos == len(e.hist.lines) {
+		e.saved = append([]rune(nil), e.buf...)
+	}
+	e.histPos = p
+	if p == len(e.hist.lines) {
+		e.buf = append([]rune(nil), e.saved...)
+	} else {
+		e.buf = []rune(e.hist.lines[p])
+	}
+	e.pos = len(e.buf)
+}
+
+/* completeWord completes the word before the cursor at the end of the line. A single
+ * candidate replaces the word, while several are completed to their common prefix, or
+ * listed if there is no common prefix to add.
+ */
+func (e *editor) completeWord() {
+	if e.complete == nil || e.pos != len(e.buf) {
+		return
+	}
+	line := string(e.buf)
+	names := e.complete(line)
+	if len(names) == 0 {
+		return
+	}
+	word := line[strings.LastIndex(line, " ")+1:]
+	if len(names) == 1 {
+		e.replaceWord(word, names[0]+" ")
+		return
+	}
+	prefix := names[0]
+	for _, name := range names[1:] {
+		for !strings.HasPrefix(name, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(prefix) > len(word) {
+		e.replaceWord(word, prefix)
+		return
+	}
+	e.clear()
+	io.WriteString(e.out, strings.Join(names, "  ")+"\n")
+}
+
+func (e *editor) replaceWord(word, with string) {
+	e.buf = append(e.buf[:len(e.buf)-len([]rune(word))], []rune(with)...)
+	e.pos = len(e.buf)
+}
+
+func (e *editor) Print(line string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.reading {
+		e.clear()
+	}
+	io.WriteString(e.out, line+"\n")
+	if e.reading {
+		e.redraw()
+	}
+}
+
+// end moves past the line being edited, which is left as it is.
+func (e *editor) end() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.reading = false
+	io.WriteString(e.out, "\n")
+}
+
+// clear erases the line being edited.
+func (e *editor) clear() {
+	io.WriteString(e.out, "\r\x1b[K")
+}
+
+// redraw draws the prompt and the line being edited, with the cursor in position.
+func (e *editor) redraw() {
+	s := "\r\x1b[K" + e.prompt + string(e.buf)
+	if n := len(e.buf) - e.pos; n > 0 {
+		s += fmt.Sprintf("\x1b[%dD", n)
+	}
+	io.WriteString(e.out, s)
+}
+
+func (e *editor) Close() error {
+	return e.restore()
+}
+
+/* parseKeys parses a comma-separated key sequence, such as "ctrl-p,ctrl-q". Keys are either
+ * a single character or "ctrl-" followed by a letter or one of "@[\]^_".
+ */
+func parseKeys(s string) ([]byte, error) {
+	var keys []byte
+	for _, key := range strings.Split(s, ",") {
+		switch {
+		case len(key) == 1:
+			keys = append(keys, key[0])
+		case len(key) == 6 && strings.HasPrefix(strings.ToLower(key), "ctrl-"):
+			c := strings.ToUpper(key[5:])[0]
+			if c < '@' || c > '_' {
+				return nil, fmt.Errorf("invalid key %q", key)
+			}
+			keys = append(keys, c-'@')
+		default:
+			return nil, fmt.Errorf("invalid key %q", key)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+/* Exit codes of the socketcmd commands, for scripting. The wrap command instead exits with
+ * the exit code of the wrapped process, once it has started.
+ */
+const (
+	// The command succeeded
+	ExitOK = 0
+	// The command failed, or the Wrapper reported an error
+	ExitFailed = 1
+	// The command line was invalid
+	ExitUsage = 2
+	// The socket could not be reached, or the wrapped process is not running or not ready
+	ExitUnavailable = 3
+	// The command is forbidden by the policy
+	ExitForbidden = 4
+	// The client exceeded the Wrapper's rate limit
+	ExitRateLimited = 5
+)
+
+// Environment variable overriding the default socket path
+const EnvSocketPath = "SOCKET_PATH"
+
+var DefaultSocketPath = "socketcmd.sock"
+
+// Time allowed to connect to the socket
+var DialTimeout = 5 * time.Second
+
+const usage = `Usage: socketcmd <command> [options] [arguments]
+
+Commands:
+  wrap     [options] -- cmd [args...]   wrap a command behind a control socket
+  send     [options] [cmd [args...]]    send a command (or commands from stdin, one per line)
+  tail     [options]                    stream the output of the wrapped process
+  console  [options]                    send commands interactively
+  attach   [options]                    attach the terminal to the wrapped process
+  playback [options] <transcript>       play back a recorded session transcript
+
+Run "socketcmd <command> -h" for the options of each command.
+`
+
+var commands = map[string]func(args []string) int{
+	"wrap":     wrapCommand,
+	"send":     sendCommand,
+	"tail":     tailCommand,
+	"console":  consoleCommand,
+	"attach":   attachCommand,
+	"playback": playbackCommand,
+}
+
+func init() {
+	// If environment variable is set, override default socket path
+	if envPath := os.Getenv(EnvSocketPath); envPath != "" {
+		DefaultSocketPath = envPath
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(ExitUsage)
+	}
+	switch os.Args[1] {
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		os.Exit(ExitOK)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "socketcmd: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(ExitUsage)
+	}
+	os.Exit(cmd(os.Args[2:]))
+}
+
+/* flagSet returns a FlagSet for the named command, which prints the given usage line
+ * followed by the command's options.
+ */
+func flagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: socketcmd %s [options] %s\n\nOptions:\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses the command line, returning the exit code if the command should not run.
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return ExitOK, false
+		}
+		return ExitUsage, false
+	}
+	return ExitOK, true
+}
+
+// clientFlags are the options shared by the commands which connect to a socket.
+type clientFlags struct {
+	socket string
+	target string
+	policy string
+}
+
+func (f *clientFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.socket, "socket", DefaultSocketPath,
+		"path of the Wrapper's socket (or $"+EnvSocketPath+")")
+	fs.StringVar(&f.target, "target", "", "instance of a Multiplexer to send commands to")
+	fs.StringVar(&f.policy, "policy", "", "policy file used to generate headers")
+}
+
+/* client returns a Client for the socket. Headers are generated from the policy file if one
+ * is given, unless a fixed header is set.
+ */
+func (f *clientFlags) client(header string) (socketcmd.Client, error) {
+	var parser socketcmd.ParseFunc
+	if header != "" {
+		if _, _, err := socketcmd.ParseHeader(header); err != nil {
+			return nil, fmt.Errorf("invalid header %q", header)
+		}
+		parser = func(_ []string) string { return header }
+	}
+	c := socketcmd.NewClient("unix", f.socket, parser)
+	c.Dialer(net.Dialer{Timeout: DialTimeout})
+	if f.policy != "" && header == "" {
+		p, err := socketcmd.LoadPolicy(f.policy)
+		if err != nil {
+			return nil, err
+		}
+		c.Policy(&p.Commands)
+	}
+	if f.target != "" {
+		c = c.Target(f.target)
+	}
+	return c, nil
+}
+
+// exitCode returns the exit code describing the given error.
+func exitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	if err == socketcmd.ErrCommandForbidden {
+		return ExitForbidden
+	}
+	var serr *socketcmd.StatusError
+	if errors.As(err, &serr) {
+		switch serr.Status {
+		case socketcmd.StatusForbidden:
+			return ExitForbidden
+		case socketcmd.StatusRateLimited:
+			return ExitRateLimited
+		case socketcmd.StatusStarting, socketcmd.StatusExited:
+			return ExitUnavailable
+		}
+		return ExitFailed
+	}
+	var nerr *net.OpError
+	if errors.As(err, &nerr) {
+		return ExitUnavailable
+	}
+	return ExitFailed
+}
+
+// fail reports the given error and returns its exit code.
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, "socketcmd:", err)
+	return exitCode(err)
+}
+
+/* A result is the JSON output of a command, in the format of the WrapperAPI's batch
+ * endpoint.
+ */
+type result struct {
+	Command string   `json:"command,omitempty"`
+	Lines   []string `json:"lines"`
+	Error   string   `json:"error,omitempty"`
+	Status  string   `json:"status,omitempty"`
+}
+
+func newResult(args, lines []string, err error) result {
+	r := result{Command: strings.Join(args, " "), Lines: lines}
+	if r.Lines == nil {
+		r.Lines = []string{}
+	}
+	if err == nil {
+		return r
+	}
+	r.Error = err.Error()
+	var serr *socketcmd.StatusError
+	if errors.As(err, &serr) {
+		r.Status = serr.Status
+	} else if err == socketcmd.ErrCommandForbidden {
+		r.Status = socketcmd.StatusForbidden
+	}
+	return r
+}
+
+// writeJSON writes the given value to stdout as a line of JSON.
+func writeJSON(v interface{}) {
+	if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, "socketcmd:", err)
+	}
+}
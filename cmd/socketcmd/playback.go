@@ -0,0 +1,61 @@
+package main
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+
+	"fmt"
+	"os"
+)
+
+/* socketcmd playback [options] <transcript>
+ *
+ * Plays back a session transcript recorded by a Wrapper, with the timing of the original
+ * session scaled by -speed. The transcript "-" is read from stdin.
+ */
+func playbackCommand(args []string) int {
+	fs := flagSet("playback", "<transcript>")
+	speed := fs.Float64("speed", 1, "playback speed, e.g. 2 for twice as fast, or 0 for no delay")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 || *speed < 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	r := os.Stdin
+	if path := fs.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fail(err)
+		}
+		defer f.Close()
+		r = f
+	}
+	events, err := socketcmd.ReadTranscript(r)
+	if err != nil {
+		return fail(fmt.Errorf("invalid transcript: %v", err))
+	}
+	if err := socketcmd.Playback(os.Stdout, events, *speed); err != nil {
+		return fail(err)
+	}
+	return ExitOK
+}
@@ -0,0 +1,90 @@
+package main
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const transcript = `{"time":"2017-01-01T00:00:00Z","kind":"command","conn":1,"source":"uid:1000","header":"1:","line":"list"}
+{"time":"2017-01-01T00:00:00.25Z","kind":"stdout","line":"There are 0 players online"}
+`
+
+// playback runs the playback command, returning its exit code and output.
+func playback(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	code := playbackCommand(args)
+	w.Close()
+	return code, <-out
+}
+
+func TestPlayback(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "session.jsonl")
+	if err := os.WriteFile(path, []byte(transcript), 0644); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "invalid.jsonl")
+	if err := os.WriteFile(invalid, []byte("not a transcript\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	code, out := playback(t, "-speed", "0", path)
+	if code != ExitOK {
+		t.Fatalf("exit code %d, want %d", code, ExitOK)
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "list") ||
+		!strings.HasSuffix(lines[1], "There are 0 players online") {
+		t.Fatalf("output %q, want the command and its output", out)
+	}
+
+	for _, test := range []struct {
+		args []string
+		code int
+	}{
+		{nil, ExitUsage},
+		{[]string{path, path}, ExitUsage},
+		{[]string{"-speed", "fast", path}, ExitUsage},
+		{[]string{"-speed", "-1", path}, ExitUsage},
+		{[]string{filepath.Join(dir, "missing.jsonl")}, ExitFailed},
+		{[]string{invalid}, ExitFailed},
+	} {
+		if code, _ := playback(t, test.args...); code != test.code {
+			t.Errorf("playback %q: exit code %d, want %d", test.args, code, test.code)
+		}
+	}
+}
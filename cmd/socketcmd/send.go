@@ -0,0 +1,131 @@
+package main
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/* socketcmd send [options] [cmd [args...]]
+ *
+ * Sends the given command, or each line of stdin as a command, and prints the responses.
+ * With -json, each response is printed as a JSON object with its lines and any error.
+ */
+func sendCommand(args []string) int {
+	var cf clientFlags
+	fs := flagSet("send", "[cmd [args...]]")
+	cf.register(fs)
+	header := fs.String("header", "", "socketcmd header, e.g. 4:500 (default from the policy, or "+
+		socketcmd.DefaultHeader+")")
+	timeout := fs.Duration("timeout", 0, "time allowed for all commands to complete (default none)")
+	retries := fs.Int("retries", 0, "number of times failed commands are retried")
+	cont := fs.Bool("continue", false, "continue after a command from stdin fails")
+	jsonOut := fs.Bool("json", false, "print responses as JSON objects")
+	structured := fs.Bool("structured", false,
+		"print the JSON object extracted from the response by the policy (requires -policy)")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	c, err := cf.client(*header)
+	if err != nil {
+		return fail(err)
+	}
+	if *retries > 0 {
+		c.Retry(socketcmd.Retry{Attempts: *retries + 1})
+	}
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	// A single command from the command line
+	if fs.NArg() > 0 {
+		if *structured {
+			fields, err := c.SendStructured(ctx, fs.Args()...)
+			if err != nil {
+				return fail(err)
+			}
+			writeJSON(fields)
+			return ExitOK
+		}
+		lines, err := c.SendContext(ctx, fs.Args()...)
+		return printResult(fs.Args(), lines, err, *jsonOut)
+	}
+	if *structured {
+		fmt.Fprintln(os.Stderr, "socketcmd: -structured requires a command")
+		return ExitUsage
+	}
+
+	// Commands from stdin, one per line, sent over one session
+	cmds, err := readCommands(os.Stdin)
+	if err != nil {
+		return fail(err)
+	}
+	mode := socketcmd.BatchStopOnError
+	if *cont {
+		mode = socketcmd.BatchContinue
+	}
+	results, err := c.SendBatch(ctx, cmds, mode)
+	if err != nil {
+		return fail(err)
+	}
+	code := ExitOK
+	for i, r := range results {
+		if rc := printResult(cmds[i], r.Lines, r.Err, *jsonOut); code == ExitOK {
+			code = rc
+		}
+	}
+	return code
+}
+
+// readCommands reads the non-empty lines of the given file as command sequences.
+func readCommands(f *os.File) ([][]string, error) {
+	var cmds [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if args := strings.Fields(scanner.Text()); len(args) > 0 {
+			cmds = append(cmds, args)
+		}
+	}
+	return cmds, scanner.Err()
+}
+
+// printResult prints the response to a command and returns the exit code of its error.
+func printResult(args, lines []string, err error, jsonOut bool) int {
+	if jsonOut {
+		writeJSON(newResult(args, lines, err))
+		return exitCode(err)
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return ExitOK
+}
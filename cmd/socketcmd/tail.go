@@ -0,0 +1,82 @@
+package main
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+/* socketcmd tail [options]
+ *
+ * Streams the Wrapper's events until interrupted. Output lines are printed as they are,
+ * and other events as in a transcript playback. With -json, events are printed as JSON lines.
+ */
+func tailCommand(args []string) int {
+	var cf clientFlags
+	fs := flagSet("tail", "")
+	cf.register(fs)
+	kinds := fs.String("kind", string(socketcmd.EventStdout),
+		"comma-separated kinds of event to stream, or \"all\"")
+	jsonOut := fs.Bool("json", false, "print events as JSON lines")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+	var selected []socketcmd.EventKind
+	if *kinds != "all" {
+		for _, kind := range strings.Split(*kinds, ",") {
+			if kind != "" {
+				selected = append(selected, socketcmd.EventKind(kind))
+			}
+		}
+	}
+
+	c, err := cf.client("")
+	if err != nil {
+		return fail(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	events, err := c.Subscribe(ctx, selected...)
+	if err != nil {
+		return fail(err)
+	}
+	for e := range events {
+		if *jsonOut {
+			writeJSON(e)
+		} else {
+			fmt.Println(e)
+		}
+	}
+	if ctx.Err() != nil {
+		return ExitOK
+	}
+	return fail(errors.New("connection to the Wrapper was closed"))
+}
@@ -0,0 +1,109 @@
+package main
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+)
+
+/* socketcmd wrap [options] -- cmd [args...]
+ *
+ * Runs the given command behind a control socket, with its stdin and stdout attached to the
+ * terminal. Exits with the exit code of the wrapped process, or 128 plus the number of the
+ * signal which terminated it.
+ */
+func wrapCommand(args []string) int {
+	fs := flagSet("wrap", "-- cmd [args...]")
+	socket := fs.String("socket", DefaultSocketPath, "path of the socket to listen on (or $"+
+		EnvSocketPath+")")
+	policy := fs.String("policy", "", "policy file, reloaded by !reload-policy")
+	record := fs.String("record", "", "file to append a transcript of the session to")
+	api := fs.String("api", "", "address to serve the HTTP API on, e.g. :8080 or unix:/path")
+	ui := fs.Bool("ui", false, "serve the web console with the HTTP API")
+	forward := fs.Bool("forward-signals", true, "relay signals to the wrapped process group")
+	ready := fs.String("ready", "", "pattern of the output line which marks the process ready")
+	gate := fs.Duration("gate", 0, "hold commands until the process is ready, for at most "+
+		"the given time (requires -ready)")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "socketcmd: you must specify a command to wrap")
+		fs.Usage()
+		return ExitUsage
+	}
+
+	w, err := socketcmd.NewUnix(*socket, socketcmd.Cmd(fs.Arg(0), fs.Args()[1:]...))
+	if err != nil {
+		return fail(err)
+	}
+	defer os.Remove(*socket)
+	if *policy != "" {
+		if err := w.PolicyFile(*policy); err != nil {
+			return fail(err)
+		}
+	}
+	if *record != "" {
+		if err := w.RecordFile(*record); err != nil {
+			return fail(err)
+		}
+	}
+	if *ready != "" {
+		pattern, err := regexp.Compile(*ready)
+		if err != nil {
+			return fail(err)
+		}
+		w.Readiness(socketcmd.Readiness{Pattern: pattern})
+		if *gate > 0 {
+			w.Gate(socketcmd.Gate{Timeout: *gate})
+		}
+	}
+	if *forward {
+		w.ForwardSignals()
+	}
+	if *api != "" {
+		// The API follows the policy as it is reloaded
+		a := w.ExposeAPI(w.ParseFunc())
+		a.UI(*ui)
+		go func() {
+			if err := a.Listen(*api, ""); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+
+	// Start the wrapped command - os.Stdin and os.Stdout are connected to the wrapped process
+	if err := w.Start(); err != nil {
+		return fail(err)
+	}
+	err = w.Wait()
+	if code := w.ExitCode(); code >= 0 {
+		return code
+	}
+	// Like a shell, report a process terminated by a signal with 128 plus the signal number
+	if code, ok := signalExit(err); ok {
+		return code
+	}
+	return ExitFailed
+}
@@ -0,0 +1,188 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+/* CompleteCommand lists the completions of its last argument, in the Handler's Policy, as
+ * JSON lines (e.g. "!complete whitelist a"). A trailing space completes the next argument
+ * instead. Only candidates which are allowed, or have allowed subcommands, are listed.
+ */
+const CompleteCommand = ControlPrefix + "complete"
+
+/* A Candidate is a completion of a command sequence, with the header matched by the
+ * completed command.
+ */
+type Candidate struct {
+	Name   string `json:"name"`
+	Header string `json:"header"`
+	// The completed command is not allowed
+	Forbidden bool `json:"forbidden,omitempty"`
+	// The completed command has subcommands which are allowed
+	Subcommands bool   `json:"subcommands,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+/* Complete returns the completions of the last argument of the given command sequence,
+ * sorted by name. The other arguments must each match a subcommand; an empty last argument
+ * lists every subcommand of the command they match.
+ */
+func (a *Argument) Complete(prefix []string) []Candidate {
+	header := a.Header
+	if header == "" {
+		header = DefaultHeader
+	}
+	partial := ""
+	if len(prefix) > 0 {
+		partial = prefix[len(prefix)-1]
+		prefix = prefix[:len(prefix)-1]
+	}
+	node := *a
+	for _, arg := range prefix {
+		next, ok := node.Args[arg]
+		if !ok {
+			return nil
+		}
+		if next.Header != "" {
+			header = next.Header
+		}
+		node = next
+	}
+
+	candidates := []Candidate{}
+	for name, arg := range node.Args {
+		if !strings.HasPrefix(name, partial) {
+			continue
+		}
+		c := Candidate{Name: name, Header: arg.Header, Description: arg.Description}
+		if c.Header == "" {
+			c.Header = header
+		}
+		c.Forbidden = c.Header == ForbiddenHeader
+		c.Subcommands = arg.allowedBelow(c.Header)
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Name < candidates[j].Name
+	})
+	return candidates
+}
+
+// allowedBelow reports whether any subcommand of the argument is allowed.
+func (a *Argument) allowedBelow(header string) bool {
+	for _, arg := range a.Args {
+		h := arg.Header
+		if h == "" {
+			h = header
+		}
+		if h != ForbiddenHeader || arg.allowedBelow(h) {
+			return true
+		}
+	}
+	return false
+}
+
+/* Complete returns the completions of the last argument of the given command sequence in
+ * the Policy. Sequences beginning with ControlPrefix are completed from the Control tree.
+ */
+func (p *Policy) Complete(prefix []string) []Candidate {
+	if len(prefix) > 0 && strings.HasPrefix(prefix[0], ControlPrefix) {
+		control := p.Control
+		if control == nil {
+			control = &DefaultControlPolicy
+		}
+		return control.Complete(prefix)
+	}
+	return p.Commands.Complete(prefix)
+}
+
+// complete returns the candidates in the Handler's Policy which the caller may use.
+func (h *handler) complete(prefix []string) []Candidate {
+	h.mu.Lock()
+	p := h.policy
+	h.mu.Unlock()
+	if p == nil {
+		p = &Policy{}
+	}
+	candidates := []Candidate{}
+	for _, c := range p.Complete(prefix) {
+		if !c.Forbidden || c.Subcommands {
+			candidates = append(candidates, c)
+		}
+	}
+	return candidates
+}
+
+// !complete [args...] - completions of the last argument as JSON lines
+func (h *handler) completeControl(args []string) ([]string, error) {
+	var lines []string
+	for _, c := range h.complete(args) {
+		b, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, string(b))
+	}
+	return lines, nil
+}
+
+func (api *wrapperAPI) CompleteEndpoint(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	// Split the input as typed, keeping an empty last argument after a trailing space
+	q := r.URL.Query().Get("q")
+	prefix := strings.Fields(q)
+	if q == "" || strings.HasSuffix(q, " ") {
+		prefix = append(prefix, "")
+	}
+
+	// Completions are authorized in the same way as the !complete control command
+	args := append([]string{CompleteCommand}, prefix...)
+	if !api.h.authorized(args) {
+		log.Printf("attempted forbidden command: %v\n", args)
+		api.h.metrics.forbid(args)
+		handlerErr(w, ErrCommandForbidden, http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(api.h.complete(prefix)); err != nil {
+		log.Println(err)
+	}
+}
+
+func (c *client) Complete(args ...string) ([]Candidate, error) {
+	lines, err := c.control(append([]string{"complete"}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]Candidate, len(lines))
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &candidates[i]); err != nil {
+			return nil, err
+		}
+	}
+	return candidates, nil
+}
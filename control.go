@@ -0,0 +1,204 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reserved prefix for control commands, which are handled by the Handler itself.
+const ControlPrefix = "!"
+
+// Number of commands retained for the !history control command
+const HistorySize = 100
+
+/* A ControlFunc implements a control command. Control commands begin with ControlPrefix
+ * and are handled by the Handler instead of being forwarded to the wrapped process.
+ */
+type ControlFunc func(args []string) ([]string, error)
+
+func (h *handler) Control(name string, fn ControlFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.controls[name] = fn
+}
+
+func (h *handler) handleControl(conn net.Conn, args []string) error {
+	h.mu.Lock()
+	fn, ok := h.controls[strings.TrimPrefix(args[0], ControlPrefix)]
+	h.mu.Unlock()
+
+	var lines []string
+	if !ok {
+		lines = []string{(&StatusError{Status: StatusUnknown, Message: args[0]}).String()}
+	} else if result, err := fn(args[1:]); err != nil {
+		lines = []string{(&StatusError{Status: StatusFailed, Message: err.Error()}).String()}
+	} else {
+		lines = result
+	}
+	for _, line := range lines {
+		if _, err := io.WriteString(conn, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record adds a command sent by the given source to the command history.
+func (h *handler) record(source, cmd string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entry := time.Now().Format(time.RFC3339) + " (" + source + ")-> " + cmd
+	if len(h.history) >= HistorySize {
+		h.history = h.history[1:]
+	}
+	h.history = append(h.history, entry)
+}
+
+// !history [n] - the n most recent commands (default all retained commands)
+func (h *handler) historyControl(args []string) ([]string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := len(h.history)
+	if len(args) > 0 {
+		i, err := strconv.Atoi(args[0])
+		if err != nil || i < 0 {
+			return nil, errors.New("invalid history length: " + args[0])
+		}
+		if i < n {
+			n = i
+		}
+	}
+	return append([]string(nil), h.history[len(h.history)-n:]...), nil
+}
+
+// !reload-policy - reload the policy file
+func (h *handler) reloadPolicyControl(_ []string) ([]string, error) {
+	h.mu.Lock()
+	path := h.policyFile
+	h.mu.Unlock()
+	if path == "" {
+		return nil, errors.New("no policy file configured")
+	}
+	if err := h.PolicyFile(path); err != nil {
+		return nil, err
+	}
+	return []string{"policy reloaded from " + path}, nil
+}
+
+/* ProcessStatus describes the state of a wrapped process, as reported by the !status
+ * control command.
+ */
+type ProcessStatus struct {
+	Health
+	State    State         `json:"state"`
+	PID      int           `json:"pid"`
+	Uptime   time.Duration `json:"uptime"`
+	Restarts int           `json:"restarts"`
+	// Exit code of the process, or -1 if it has not exited or was terminated by a signal
+	ExitCode int `json:"exit_code"`
+}
+
+// control sends a control command, bypassing the Client's parser.
+func (c *client) control(args ...string) ([]string, error) {
+	conn, err := c.dialContext(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	args[0] = ControlPrefix + args[0]
+	return c.send(conn, DefaultHeader, args...)
+}
+
+// controlValue sends a control command that responds with a single line.
+func (c *client) controlValue(args ...string) (string, error) {
+	lines, err := c.control(args...)
+	if err != nil {
+		return "", err
+	}
+	if len(lines) != 1 {
+		return "", errors.New("unexpected control response: " + strings.Join(lines, "\n"))
+	}
+	return lines[0], nil
+}
+
+func (c *client) Health() (Health, error) {
+	var health Health
+	line, err := c.controlValue("health")
+	if err == nil {
+		err = json.Unmarshal([]byte(line), &health)
+	}
+	return health, err
+}
+
+func (c *client) Status() (ProcessStatus, error) {
+	var status ProcessStatus
+	line, err := c.controlValue("status")
+	if err == nil {
+		err = json.Unmarshal([]byte(line), &status)
+	}
+	return status, err
+}
+
+func (c *client) PID() (int, error) {
+	line, err := c.controlValue("pid")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(line)
+}
+
+func (c *client) Uptime() (time.Duration, error) {
+	line, err := c.controlValue("uptime")
+	if err != nil {
+		return 0, err
+	}
+	return time.ParseDuration(line)
+}
+
+func (c *client) History() ([]string, error) {
+	return c.control("history")
+}
+
+func (c *client) Restart() error {
+	_, err := c.control("restart")
+	return err
+}
+
+func (c *client) Stop() error {
+	_, err := c.control("stop")
+	return err
+}
+
+func (c *client) Signal(sig string) error {
+	_, err := c.control("signal", sig)
+	return err
+}
+
+func (c *client) ReloadPolicy() error {
+	_, err := c.control("reload-policy")
+	return err
+}
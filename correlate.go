@@ -0,0 +1,125 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+/* A Correlation configures how a Handler separates the response to a command from other
+ * output of the process (such as a player joining) printed at the same time.
+ *
+ * If the process supports it, the command is surrounded by marker commands which make the
+ * process print a line containing a unique marker (for example "echo %s" for a shell, or
+ * "say %s" for a game server). Output before the first marker and the marker lines
+ * themselves are discarded, and the response ends at the second marker. Since the markers
+ * only bound the response in time, unrelated output printed while the command runs may
+ * still be included.
+ *
+ * Regardless of markers, lines of output which do not match the "response" pattern of the
+ * command in the Handler's Policy (if defined) are discarded from its response.
+ */
+type Correlation struct {
+	// Command printing the marker, with %s replaced by the marker; markers are not used if empty
+	Marker string
+}
+
+func (h *handler) Correlate(c Correlation) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.correlation = c
+}
+
+// A responseFilter selects the lines of output which belong to the response to a command.
+type responseFilter struct {
+	begin   string
+	end     string
+	started bool
+	pattern *regexp.Regexp
+}
+
+/* accept reports whether the given line of output belongs to the response, and whether the
+ * response is complete.
+ */
+func (f *responseFilter) accept(line string) (keep, complete bool) {
+	if f == nil {
+		return true, false
+	}
+	if f.begin != "" && !f.started {
+		f.started = strings.Contains(line, f.begin)
+		return false, false
+	}
+	if f.end != "" && strings.Contains(line, f.end) {
+		return false, true
+	}
+	return f.pattern == nil || f.pattern.MatchString(line), false
+}
+
+/* responseFilter returns the filter for the response to the given command, with the marker
+ * commands to send before and after it (if any).
+ */
+func (h *handler) responseFilter(cmd string) (f *responseFilter, before, after string) {
+	h.mu.Lock()
+	c, p := h.correlation, h.policy
+	h.mu.Unlock()
+
+	if p != nil {
+		if expr := p.Commands.MatchResponse(strings.Fields(cmd)); expr != "" {
+			f = &responseFilter{pattern: h.compile(expr)}
+		}
+	}
+	if c.Marker != "" {
+		nonce := make([]byte, 8)
+		if _, err := rand.Read(nonce); err != nil {
+			log.Println(err)
+			return f, "", ""
+		}
+		if f == nil {
+			f = &responseFilter{}
+		}
+		id := hex.EncodeToString(nonce)
+		f.begin, f.end = "socketcmd-begin-"+id, "socketcmd-end-"+id
+		before, after = fmt.Sprintf(c.Marker, f.begin), fmt.Sprintf(c.Marker, f.end)
+	}
+	return f, before, after
+}
+
+// compile returns the compiled response pattern, which is cached for later commands.
+func (h *handler) compile(expr string) *regexp.Regexp {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if re, ok := h.patterns[expr]; ok {
+		return re
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		// Invalid patterns are logged once, and do not filter the response
+		log.Printf("invalid response pattern %q: %v\n", expr, err)
+	}
+	if h.patterns == nil {
+		h.patterns = make(map[string]*regexp.Regexp)
+	}
+	h.patterns[expr] = re
+	return re
+}
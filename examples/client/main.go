@@ -21,6 +21,7 @@ package main
 import (
 	"github.com/faceless-saint/go-socketcmd"
 
+	"fmt"
 	"os"
 	"strings"
 )
@@ -0,0 +1,50 @@
+package main
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+
+	"errors"
+	"os"
+	"strconv"
+)
+
+func main() {
+	// Usage: playback <transcript> [speed]
+	if len(os.Args) < 2 {
+		panic(errors.New("not enough arguments - you must specify a transcript file"))
+	}
+	speed := 1.0
+	if len(os.Args) > 2 {
+		var err error
+		if speed, err = strconv.ParseFloat(os.Args[2], 64); err != nil {
+			panic(err)
+		}
+	}
+
+	// Play back the recorded session with its original timing, scaled by the given speed
+	events, err := socketcmd.LoadTranscript(os.Args[1])
+	if err != nil {
+		panic(err)
+	}
+	if err := socketcmd.Playback(os.Stdout, events, speed); err != nil {
+		panic(err)
+	}
+}
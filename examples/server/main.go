@@ -25,7 +25,10 @@ import (
 	"os"
 )
 
-const EnvSocketPath = "SOCKET_PATH"
+const (
+	EnvSocketPath = "SOCKET_PATH"
+	EnvSessionLog = "SESSION_LOG"
+)
 
 var ExampleSocketPath = "@example.sock"
 
@@ -54,6 +57,13 @@ func main() {
 		panic(err)
 	}
 
+	// If environment variable is set, record a transcript of the session
+	if path := os.Getenv(EnvSessionLog); path != "" {
+		if err := s.RecordFile(path); err != nil {
+			panic(err)
+		}
+	}
+
 	// Start the wrapped command - os.Stdin and os.Stdout are connected to the wrapped process
 	if err := s.Run(); err != nil {
 		panic(err)
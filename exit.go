@@ -0,0 +1,112 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"io"
+	"strings"
+	"time"
+)
+
+// Number of trailing lines of output sent to clients after the process exits
+var ExitTailLines = 10
+
+// Time to wait for the exit status to be recorded after the process output closes
+var exitStatusDelay = 100 * time.Millisecond
+
+// State of a wrapped process.
+type State string
+
+const (
+	StateIdle       State = "idle"
+	StateRunning    State = "running"
+	StateRestarting State = "restarting"
+	StateExited     State = "exited"
+)
+
+// tailLine adds a line of stdout to the output tail. Requires h.mu.
+func (h *handler) tailLine(line string) {
+	if ExitTailLines <= 0 {
+		return
+	}
+	if len(h.tail) >= ExitTailLines {
+		h.tail = h.tail[len(h.tail)-ExitTailLines+1:]
+	}
+	h.tail = append(h.tail, line)
+}
+
+/* exit records a description of how the process exited, which is reported to clients in
+ * place of a response until the Handler is attached to a new process.
+ */
+func (h *handler) exit(description string) {
+	h.mu.Lock()
+	recorded := h.exitStatus == ""
+	if recorded {
+		h.exitStatus = description
+		close(h.exitCh)
+	}
+	h.mu.Unlock()
+	if recorded {
+		h.event(EventExit, 0, "", "", description)
+	}
+}
+
+/* exited returns the exit status and output tail if the process output is closed. If the
+ * process exit has not been recorded, the closed output is reported instead.
+ */
+func (h *handler) exited() (*StatusError, []string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stdoutOpen {
+		return nil, nil, false
+	}
+	msg := h.exitStatus
+	if msg == "" {
+		msg = "process output closed"
+	}
+	return &StatusError{Status: StatusExited, Message: msg}, append([]string(nil), h.tail...), true
+}
+
+/* writeExited sends the exit status to the client if the process exited, followed by the
+ * output tail if requested. Since the process output closes before the exit is recorded,
+ * the exit status is given a short time to become available.
+ */
+func (h *handler) writeExited(w io.Writer, withTail bool) (bool, error) {
+	h.mu.Lock()
+	exitCh, open := h.exitCh, h.stdoutOpen
+	h.mu.Unlock()
+	if open {
+		return false, nil
+	}
+	select {
+	case <-exitCh:
+	case <-time.After(exitStatusDelay):
+	}
+
+	status, tail, ok := h.exited()
+	if !ok {
+		return false, nil
+	}
+	lines := []string{status.String()}
+	if withTail {
+		lines = append(lines, tail...)
+	}
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return true, err
+}
@@ -0,0 +1,47 @@
+package socketcmd_test
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+	"github.com/faceless-saint/go-socketcmd/socketcmdtest"
+
+	"testing"
+	"time"
+)
+
+func TestExitStatus(t *testing.T) {
+	s := socketcmdtest.NewScript().On("ping", "pong").CrashOn("stop", 3)
+	w, c := newWrapper(t, s)
+	lines, err := c.Send("ping")
+	expect(t, lines, err, "pong")
+
+	c.Send("stop")
+	select {
+	case <-w.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the process to exit")
+	}
+	if code := w.ExitCode(); code != 3 {
+		t.Errorf("ExitCode() = %d, want 3", code)
+	}
+	// Commands sent after the process exited are refused with its exit status
+	_, err = c.Send("ping")
+	expectStatus(t, err, socketcmd.StatusExited)
+}
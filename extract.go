@@ -0,0 +1,170 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNoExtractor = errors.New("no extractor is defined for the command")
+	ErrNoMatch     = errors.New("the response did not match the extractor")
+)
+
+/* An Extractor turns the response lines of a command into a JSON object. Lines are matched
+ * against a regular expression with named capture groups, or a template in which each
+ * "{name}" placeholder matches any text:
+ *
+ *	{"template": "There are {count} of a max of {max} players online: {players}",
+ *	 "types": {"count": "int", "max": "int", "players": "list"}}
+ *
+ * The fields of the first matching line are returned, or with Multiple set, the fields of
+ * each matching line as the "items" array. Fields are strings unless given one of the
+ * types "int", "float", "bool" or "list" (split on commas).
+ */
+type Extractor struct {
+	Pattern  string            `json:"pattern,omitempty"`
+	Template string            `json:"template,omitempty"`
+	Types    map[string]string `json:"types,omitempty"`
+	Multiple bool              `json:"multiple,omitempty"`
+
+	once sync.Once
+	re   *regexp.Regexp
+	err  error
+}
+
+// Extract the fields of the given response lines.
+func (e *Extractor) Extract(lines []string) (map[string]interface{}, error) {
+	re, err := e.compile()
+	if err != nil {
+		return nil, err
+	}
+	items := []interface{}{}
+	for _, line := range lines {
+		m := re.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		fields := make(map[string]interface{})
+		for i, name := range re.SubexpNames() {
+			if name == "" {
+				continue
+			}
+			if fields[name], err = convertField(m[i], e.Types[name]); err != nil {
+				return nil, errors.New(name + ": " + err.Error())
+			}
+		}
+		if !e.Multiple {
+			return fields, nil
+		}
+		items = append(items, fields)
+	}
+	if !e.Multiple {
+		return nil, ErrNoMatch
+	}
+	return map[string]interface{}{"items": items}, nil
+}
+
+// compile the pattern or template of the Extractor, which is done once.
+func (e *Extractor) compile() (*regexp.Regexp, error) {
+	e.once.Do(func() {
+		expr := e.Pattern
+		if expr == "" {
+			expr = templatePattern(e.Template)
+		}
+		e.re, e.err = regexp.Compile(expr)
+	})
+	return e.re, e.err
+}
+
+var placeholder = regexp.MustCompile(`\{(\w+)\}`)
+
+// templatePattern converts a template into an anchored regular expression.
+func templatePattern(tmpl string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	last := 0
+	for _, loc := range placeholder.FindAllStringSubmatchIndex(tmpl, -1) {
+		b.WriteString(regexp.QuoteMeta(tmpl[last:loc[0]]))
+		b.WriteString("(?P<" + tmpl[loc[2]:loc[3]] + ">.*?)")
+		last = loc[1]
+	}
+	b.WriteString(regexp.QuoteMeta(tmpl[last:]))
+	b.WriteString("$")
+	return b.String()
+}
+
+// convertField converts an extracted value to the given type.
+func convertField(value, typ string) (interface{}, error) {
+	switch typ {
+	case "int":
+		return strconv.Atoi(strings.TrimSpace(value))
+	case "float":
+		return strconv.ParseFloat(strings.TrimSpace(value), 64)
+	case "bool":
+		return strconv.ParseBool(strings.TrimSpace(value))
+	case "list":
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return list, nil
+	case "", "string":
+		return value, nil
+	}
+	return nil, errors.New("unknown field type: " + typ)
+}
+
+/* MatchExtractor returns the most specific Extractor for the given command sequence, or nil
+ * if none is defined.
+ */
+func (a *Argument) MatchExtractor(args []string) *Extractor {
+	var extract *Extractor
+	a.walk(args, func(_ int, arg *Argument) {
+		if arg.Extract != nil {
+			extract = arg.Extract
+		}
+	})
+	return extract
+}
+
+/* SendStructured sends the given arguments to the socket Wrapper, and extracts the fields
+ * of the response with the Extractor defined for the command in the Client's policy.
+ */
+func (c *client) SendStructured(ctx context.Context, args ...string) (map[string]interface{}, error) {
+	if c.policy == nil {
+		return nil, ErrNoExtractor
+	}
+	extract := c.policy.MatchExtractor(args)
+	if extract == nil {
+		return nil, ErrNoExtractor
+	}
+	lines, err := c.SendContext(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return extract.Extract(lines)
+}
@@ -0,0 +1,214 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrUnsupportedSignal = errors.New("signals are not supported for attached processes")
+
+// Interval between checks for new output in a tailed log file
+var TailPollInterval = 250 * time.Millisecond
+
+/* NewFIFOHandler returns a new Handler for a process which is already running under
+ * another supervisor. Commands are written to the named pipe (FIFO) at the given path, and
+ * responses are read from the end of the given log file, which may be rotated or truncated.
+ */
+func NewFIFOHandler(listener net.Listener, fifo, logfile string) (Handler, error) {
+	p, err := openFIFOProcess(fifo, logfile)
+	if err != nil {
+		return nil, err
+	}
+	return newHandler(listener, p.Stdin(), p.Stdout()), nil
+}
+
+/* AttachFIFO returns a new socket Wrapper for a process which is already running under
+ * another supervisor, using the given FIFO and log file as in NewFIFOHandler. The process
+ * is not owned by the Wrapper: stopping the Wrapper detaches it from the process, restarting
+ * reopens the FIFO and log file, and other signals are not supported.
+ */
+func AttachFIFO(listener net.Listener, fifo, logfile string) (Wrapper, error) {
+	if listener == nil || fifo == "" || logfile == "" {
+		return nil, errors.New("missing required parameters")
+	}
+	w, err := newWrapper(listener, func() (Process, error) {
+		return openFIFOProcess(fifo, logfile)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// fifoProcess is a process attached through a FIFO and a log file.
+type fifoProcess struct {
+	in  *os.File
+	out *tailReader
+
+	once     sync.Once
+	detached chan struct{}
+}
+
+func openFIFOProcess(fifo, logfile string) (*fifoProcess, error) {
+	// Opening for reading and writing does not block until the process opens the FIFO
+	in, err := os.OpenFile(fifo, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	out, err := openTail(logfile)
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	return &fifoProcess{in: in, out: out, detached: make(chan struct{})}, nil
+}
+
+func (p *fifoProcess) Start() error      { return nil }
+func (p *fifoProcess) Stdin() io.Writer  { return p.in }
+func (p *fifoProcess) Stdout() io.Reader { return p.out }
+func (p *fifoProcess) Stderr() io.Reader { return nil }
+
+// Wait blocks until the process is detached.
+func (p *fifoProcess) Wait() error {
+	<-p.detached
+	return nil
+}
+
+// Signal detaches from the process for terminating signals; others are not supported.
+func (p *fifoProcess) Signal(sig os.Signal) error {
+	if sig != terminateSignal && sig != os.Kill {
+		return ErrUnsupportedSignal
+	}
+	p.once.Do(func() {
+		p.in.Close()
+		p.out.Close()
+		close(p.detached)
+	})
+	return nil
+}
+
+/* A tailReader reads lines appended to a log file, starting from its current end. When
+ * the file is rotated (replaced), reading continues from the start of the new file once the
+ * old one has been read to the end. When it is truncated, reading continues from the start.
+ */
+type tailReader struct {
+	path string
+
+	mu     sync.Mutex
+	f      *os.File
+	fi     os.FileInfo
+	offset int64
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+func openTail(path string) (*tailReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &tailReader{path: path, f: f, fi: fi, offset: offset, closed: make(chan struct{})}, nil
+}
+
+func (t *tailReader) Read(b []byte) (int, error) {
+	for {
+		t.mu.Lock()
+		n, err := t.f.Read(b)
+		t.offset += int64(n)
+		t.mu.Unlock()
+		if n > 0 {
+			return n, nil
+		}
+		select {
+		case <-t.closed:
+			return 0, io.EOF
+		default:
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		// Wait for more output, then check for rotation
+		select {
+		case <-t.closed:
+			return 0, io.EOF
+		case <-time.After(TailPollInterval):
+		}
+		if err := t.follow(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+/* follow reopens the log file if it was rotated and the old file has been read to the end,
+ * or rewinds it if it was truncated.
+ */
+func (t *tailReader) follow() error {
+	fi, err := os.Stat(t.path)
+	if err != nil {
+		// The log file may be briefly missing while it is rotated
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !os.SameFile(fi, t.fi) {
+		// Like tail -F, the rest of the old file is read before switching to the new one
+		if old, err := t.f.Stat(); err == nil && old.Size() > t.offset {
+			return nil
+		}
+		f, err := os.Open(t.path)
+		if err != nil {
+			return nil
+		}
+		t.f.Close()
+		t.f, t.fi, t.offset = f, fi, 0
+		return nil
+	}
+	if fi.Size() < t.offset {
+		if _, err := t.f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		t.offset = 0
+	}
+	return nil
+}
+
+func (t *tailReader) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.f.Close()
+}
@@ -0,0 +1,75 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTailRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "latest.log")
+	if err := os.WriteFile(path, []byte("before\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tail, err := openTail(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tail.Close()
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(tail)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	// Wait for the reader to reach the end of the file
+	time.Sleep(TailPollInterval / 2)
+
+	// Lines appended just before the file is rotated are read before the new file's lines
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("old 1\nold 2\n")
+	f.Close()
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("new 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"old 1", "old 2", "new 1"} {
+		select {
+		case line := <-lines:
+			if line != want {
+				t.Fatalf("read %q, want %q", line, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+}
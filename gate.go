@@ -0,0 +1,115 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var ErrExitedBeforeReady = fmt.Errorf("process output closed before it became ready")
+
+/* A Gate holds socket commands after the Handler is started until the wrapped process is
+ * ready, as configured by its Readiness. Control commands are never held.
+ */
+type Gate struct {
+	// Refuse held commands with a "starting" status instead of queueing them
+	Fail bool
+	// Release held commands once this much time has passed since starting, if positive
+	Timeout time.Duration
+}
+
+func (h *handler) Gate(g Gate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.gate = &g
+}
+
+// markReady marks the process as ready, releasing any held commands. Requires h.mu.
+func (h *handler) markReady() {
+	if !h.ready {
+		h.ready = true
+		close(h.readyCh)
+	}
+}
+
+func (h *handler) WaitReady(ctx context.Context) error {
+	h.mu.Lock()
+	ready, done := h.readyCh, h.done
+	h.mu.Unlock()
+	select {
+	case <-ready:
+		return nil
+	case <-done:
+		return ErrExitedBeforeReady
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/* waitGate holds a command until the process is ready or the gate times out. If the gate
+ * is configured to fail instead, a StatusError is returned while the process is starting.
+ */
+func (h *handler) waitGate() error {
+	h.mu.Lock()
+	gate, ready, done := h.gate, h.readyCh, h.done
+	deadline := h.started.Add(gate.timeout())
+	h.mu.Unlock()
+	if gate == nil {
+		return nil
+	}
+	select {
+	case <-ready:
+		return nil
+	default:
+	}
+
+	var timeout <-chan time.Time
+	if gate.Timeout > 0 {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil
+		}
+		t := time.NewTimer(remaining)
+		defer t.Stop()
+		timeout = t.C
+	}
+	if gate.Fail {
+		retry := time.Until(deadline)
+		if gate.Timeout <= 0 {
+			retry = DefaultProbeInterval
+		}
+		return &StatusError{StatusStarting, "process is not ready", retry}
+	}
+	select {
+	case <-ready:
+	case <-timeout:
+	case <-done:
+	}
+	return nil
+}
+
+// timeout returns the gate timeout, or zero for a nil Gate.
+func (g *Gate) timeout() time.Duration {
+	if g == nil {
+		return 0
+	}
+	return g.Timeout
+}
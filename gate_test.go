@@ -0,0 +1,84 @@
+package socketcmd_test
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+	"github.com/faceless-saint/go-socketcmd/socketcmdtest"
+
+	"regexp"
+	"testing"
+	"time"
+)
+
+// startup is the time after which the scripted process reports that it is ready.
+const startup = 200 * time.Millisecond
+
+func readyScript() *socketcmdtest.Script {
+	return socketcmdtest.NewScript().
+		On("ping", "pong").
+		Emit(startup, "Server ready")
+}
+
+func readiness(g socketcmd.Gate) func(socketcmd.Wrapper) {
+	return func(w socketcmd.Wrapper) {
+		w.Readiness(socketcmd.Readiness{Pattern: regexp.MustCompile("ready")})
+		w.Gate(g)
+	}
+}
+
+func TestGateHold(t *testing.T) {
+	start := time.Now()
+	_, c := newWrapper(t, readyScript(), readiness(socketcmd.Gate{}))
+
+	// The command is held until the process is ready
+	lines, err := c.Send("ping")
+	expect(t, lines, err, "pong")
+	if elapsed := time.Since(start); elapsed < startup {
+		t.Errorf("command answered after %v, before the process was ready", elapsed)
+	}
+}
+
+func TestGateFail(t *testing.T) {
+	w, c := newWrapper(t, readyScript(), readiness(socketcmd.Gate{Fail: true}))
+
+	// The command is refused while the process is starting
+	_, err := c.Send("ping")
+	if serr := expectStatus(t, err, socketcmd.StatusStarting); serr.RetryAfter <= 0 {
+		t.Errorf("RetryAfter = %v, want a delay", serr.RetryAfter)
+	}
+	if err := w.WaitReady(contextTimeout(t, 5*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	lines, err := c.Send("ping")
+	expect(t, lines, err, "pong")
+}
+
+func TestGateTimeout(t *testing.T) {
+	s := socketcmdtest.NewScript().On("ping", "pong")
+	start := time.Now()
+	_, c := newWrapper(t, s, readiness(socketcmd.Gate{Timeout: startup}))
+
+	// The process never reports that it is ready, so the command is released by the timeout
+	lines, err := c.Send("ping")
+	expect(t, lines, err, "pong")
+	if elapsed := time.Since(start); elapsed < startup {
+		t.Errorf("command answered after %v, before the gate timed out", elapsed)
+	}
+}
@@ -20,12 +20,17 @@ package socketcmd
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -43,18 +48,66 @@ type Handler interface {
 	Addr() net.Addr
 	// Close the socket listener.
 	Close() error
+	// Limit the rate at which socket clients may send commands.
+	Limit(RateLimiter)
 	// Start the goroutines for managing process I/O redirection.
 	Start()
+	// WriteMetrics writes the Handler's metrics in the Prometheus text format.
+	WriteMetrics(io.Writer) error
+	// Health returns the current state of the process I/O.
+	Health() Health
+	// Readiness configures how the Handler determines that the process is ready.
+	Readiness(Readiness)
+	// Gate holds socket commands until the process is ready.
+	Gate(Gate)
+	// WaitReady blocks until the process is ready or the context is done.
+	WaitReady(context.Context) error
+	// Policy authorizes commands and control commands received on the socket.
+	Policy(*Policy)
+	// PolicyFile loads the Policy from the given file, which is reloaded by !reload-policy.
+	PolicyFile(path string) error
+	// Control registers a control command with the given name (without ControlPrefix).
+	Control(name string, fn ControlFunc)
+	// Record writes a transcript of the session to the given Writer (nil to stop).
+	Record(io.Writer)
+	// RecordFile appends a transcript of the session to the named file.
+	RecordFile(path string) error
+	// Correlate configures how responses are separated from unrelated process output.
+	Correlate(Correlation)
 }
 
 /* NewHandler returns a new Handler for the given socket listener and I/O pipes.
  */
 func NewHandler(listener net.Listener, stdin io.Writer, stdout io.Reader) Handler {
-	return &handler{listener, stdin, stdout,
-		make(chan string, 0),
-		make(chan string, 0),
-		make(chan bool, 1),
+	return newHandler(listener, stdin, stdout)
+}
+
+func newHandler(listener net.Listener, stdin io.Writer, stdout io.Reader) *handler {
+	h := &handler{
+		Socket: listener,
+		Stdin:  stdin,
+		Stdout: stdout,
+
+		rch: make(chan string, 0),
+		wch: make(chan string, 0),
+		// Unbuffered, so that the consumer is blocked before an exchange writes its command
+		blk: make(chan bool),
+
+		input: os.Stdin,
+		echo:  os.Stdout,
+
+		metrics:  newMetrics(),
+		controls: make(map[string]ControlFunc),
+
+		readyCh: make(chan struct{}),
+		done:    make(chan struct{}),
+		exitCh:  make(chan struct{}),
 	}
+	h.controls["health"] = healthControl(h.Health)
+	h.controls["complete"] = h.completeControl
+	h.controls["history"] = h.historyControl
+	h.controls["reload-policy"] = h.reloadPolicyControl
+	return h
 }
 
 type handler struct {
@@ -65,6 +118,39 @@ type handler struct {
 	rch chan string
 	wch chan string
 	blk chan bool
+
+	// Terminal I/O attached to the process, if any
+	input io.Reader
+	echo  io.Writer
+
+	limiter  RateLimiter
+	metrics  *metrics
+	controls map[string]ControlFunc
+	history  []string
+
+	xmu        sync.Mutex
+	mu         sync.Mutex
+	readiness  Readiness
+	ready      bool
+	readyCh    chan struct{}
+	gate       *Gate
+	started    time.Time
+	stdoutOpen bool
+	done       chan struct{}
+	policy     *Policy
+	policyFile string
+	tail       []string
+	exitStatus string
+	exitCh     chan struct{}
+	rec        *recorder
+	conns      uint64
+	subs       map[*subscriber]struct{}
+	// Names of the attached operators by connection, and the holder of the write lock
+	operators  map[uint64]string
+	lockHolder uint64
+
+	correlation Correlation
+	patterns    map[string]*regexp.Regexp
 }
 
 func (h *handler) Addr() net.Addr {
@@ -75,11 +161,46 @@ func (h *handler) Close() error {
 	return h.Socket.Close()
 }
 
+func (h *handler) Limit(limiter RateLimiter) {
+	h.limiter = limiter
+}
+
+func (h *handler) WriteMetrics(w io.Writer) error {
+	_, err := h.metrics.WriteTo(w)
+	return err
+}
+
 func (h *handler) Start() {
 	go h.HandleSocket()
 	go h.HandleStdin()
-	go h.ListenStdin()
+	if h.input != nil {
+		go h.ListenStdin()
+	}
+	go h.consumeStdout()
+	h.attach(h.Stdin, h.Stdout)
+}
+
+/* attach connects the Handler to the I/O pipes of a newly started process, replacing the
+ * pipes of any previous process.
+ */
+func (h *handler) attach(stdin io.Writer, stdout io.Reader) {
+	h.mu.Lock()
+	h.Stdin, h.Stdout = stdin, stdout
+	h.stdoutOpen = true
+	h.started = time.Now()
+	h.done = make(chan struct{})
+	h.tail, h.exitStatus = nil, ""
+	h.exitCh = make(chan struct{})
+	if h.ready {
+		h.ready = false
+		h.readyCh = make(chan struct{})
+	}
+	if h.readiness.Pattern == nil && len(h.readiness.Probe) == 0 {
+		h.markReady()
+	}
+	h.mu.Unlock()
 	go h.ListenStdout()
+	go h.probeReadiness()
 }
 
 /* goroutine: forward socket connections to the wrapped process
@@ -95,15 +216,37 @@ func (h *handler) HandleSocket() {
 			log.Println(err)
 			continue
 		}
-		if err := h.handleConnection(conn); err != nil {
+		// Connections are handled concurrently, since sessions may remain open
+		go func() {
+			if err := h.handleConnection(conn); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+}
+
+/* dialLocal returns a new in-process connection to the Handler, which is handled like a
+ * connection accepted from its socket. Connections from within this process are exempt
+ * from rate limits.
+ */
+func (h *handler) dialLocal(_ context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+	go func() {
+		if err := h.handleConnection(&localConn{server}); err != nil {
 			log.Println(err)
 		}
-	}
+	}()
+	return client, nil
 }
 
 func (h *handler) handleConnection(conn net.Conn) error {
 	defer conn.Close() // close the connection when finished
 
+	// Record the connection boundaries in the session transcript
+	id, source := atomic.AddUint64(&h.conns, 1), conn.RemoteAddr().String()
+	h.event(EventConnect, id, source, "", "")
+	defer h.event(EventDisconnect, id, source, "", "")
+
 	// Read the command from the socket connection
 	buf := make([]byte, ConnBufferSize)
 	n, err := conn.Read(buf)
@@ -111,42 +254,168 @@ func (h *handler) handleConnection(conn net.Conn) error {
 		_, err2 := io.WriteString(conn, err.Error()+"\n")
 		return err2
 	}
+	return h.handleCommand(conn, id, source, string(buf[:n]), false)
+}
 
+/* handleCommand handles a single command received on the socket connection, writing the
+ * response to the connection. Commands received in session mode may not start a new session.
+ */
+func (h *handler) handleCommand(conn net.Conn, id uint64, source, msg string, inSession bool) error {
 	// [lines]:[timeout] args...
-	words := strings.SplitN(string(buf[:n]), " ", 2)
+	words := strings.SplitN(msg, " ", 2)
 	if len(words) < 2 {
 		words = append(words, "")
 	}
 
 	// Parse header word for line count and timeout information
+	args := signalArgs(strings.Fields(words[1]))
 	lines, timeout, err := ParseHeader(words[0])
 	if err != nil {
+		h.metrics.command(args, "error")
 		_, err2 := io.WriteString(conn, err.Error()+"\n")
 		return err2
 	}
 
-	// Block the response consumer while handling the connection
-	h.blk <- true
-	defer func() { h.blk <- false }()
+	// Enforce rate limits for the connecting client
+	if ok, wait := h.allow(conn, args); !ok {
+		h.metrics.command(args, "rate_limited")
+		status := &StatusError{StatusRateLimited, "too many commands", wait}
+		_, err := io.WriteString(conn, status.String()+"\n")
+		return err
+	}
+
+	// Enforce the command policy. Sessions opened in-process by the WrapperAPI are exempt,
+	// since each command sent over them is authorized in turn
+	if !inProcessSession(conn, args) && !h.authorized(args) {
+		h.metrics.forbid(args)
+		log.Printf("(%s) attempted forbidden command: %s\n", source, words[1])
+		status := &StatusError{Status: StatusForbidden, Message: ErrCommandForbidden.Error()}
+		_, err := io.WriteString(conn, status.String()+"\n")
+		return err
+	}
+	h.metrics.command(args, "ok")
+
+	// Control commands are handled without involving the wrapped process
+	if strings.HasPrefix(words[1], ControlPrefix) {
+		h.event(EventControl, id, source, words[0], words[1])
+		switch args[0] {
+		case SessionCommand:
+			return h.handleSession(conn, id, source, inSession)
+		case SubscribeCommand:
+			return h.handleSubscribe(conn, args[1:], inSession)
+		case AttachCommand:
+			return h.handleAttach(conn, id, args[1:], inSession)
+		case CompleteCommand:
+			// A trailing space completes the next argument, rather than the last
+			if strings.HasSuffix(words[1], " ") {
+				args = append(args, "")
+			}
+		}
+		return h.handleControl(conn, args)
+	}
+
+	// Report the exit status instead if the process has exited
+	if ok, err := h.writeExited(conn, true); ok {
+		return err
+	}
+
+	// Hold the command until the process is ready
+	if err := h.waitGate(); err != nil {
+		_, err2 := io.WriteString(conn, err.(*StatusError).String()+"\n")
+		return err2
+	}
 
 	// Send command to the stdin Writer
-	log.Printf("(%s)-> %s\n", conn.RemoteAddr().String(), words[1])
-	h.wch <- words[1]
+	log.Printf("(%s)-> %s\n", source, words[1])
+	h.record(source, words[1])
+	h.event(EventCommand, id, source, words[0], words[1])
+	start := time.Now()
 
 	// Send the captured response to the socket connection
-	return sendResponse(conn, h.rch, lines, timeout)
+	count, err := h.exchange(words[1], lines, timeout, conn)
+	h.metrics.response(count, time.Since(start))
+	return err
+}
+
+/* exchange sends a command to the stdin Writer and copies the captured response to the
+ * given Writer.
+ */
+func (h *handler) exchange(cmd string, lines, timeout int, w io.Writer) (int, error) {
+	// Exchanges from concurrent connections are handled one at a time
+	h.xmu.Lock()
+	defer h.xmu.Unlock()
+
+	// Block the response consumer while handling the exchange
+	h.blk <- true
+	defer func() { h.blk <- false }()
+
+	h.mu.Lock()
+	done := h.done
+	h.mu.Unlock()
+
+	filter, before, after := h.responseFilter(cmd)
+	if before != "" {
+		h.write(before)
+	}
+	h.write(cmd)
+	if after != "" {
+		h.write(after)
+	}
+	count, err := sendResponse(w, h.rch, done, lines, timeout, filter)
+	if err != nil {
+		return count, err
+	}
+
+	// Report the exit status if the process exited during the response
+	select {
+	case <-done:
+		_, err = h.writeExited(w, false)
+	default:
+	}
+	return count, err
 }
 
-func sendResponse(conn net.Conn, resp <-chan string, lines, timeout int) error {
-	var count int
+// write queues a line of input for the stdin Writer.
+func (h *handler) write(input string) {
+	h.metrics.queue(1)
+	h.wch <- input
+}
+
+/* allow checks the rate limiter for the given connection and command. In-process
+ * connections are exempt, since the WrapperAPI applies limits per HTTP client before
+ * forwarding commands over them. Socket clients in this process are limited like any other.
+ */
+func (h *handler) allow(conn net.Conn, args []string) (bool, time.Duration) {
+	if h.limiter == nil {
+		return true, 0
+	}
+	if _, ok := conn.(*localConn); ok {
+		return true, 0
+	}
+	return h.limiter.Allow(ConnIdentity(conn), args)
+}
+
+// inProcessSession reports whether the command opens a session on an in-process connection.
+func inProcessSession(conn net.Conn, args []string) bool {
+	_, ok := conn.(*localConn)
+	return ok && len(args) > 0 && args[0] == SessionCommand
+}
+
+/* sendResponse copies lines of the response to the connection until the line count or
+ * timeout is reached, or the process output closes. Lines are selected by the filter, if
+ * any, which may also end the response.
+ */
+func sendResponse(conn io.Writer, resp <-chan string, done <-chan struct{}, lines, timeout int,
+	filter *responseFilter,
+) (count int, err error) {
 	// Use default timeout if given value is out of bounds
 	if timeout <= 0 {
 		timeout = DefaultTimeout
 	}
 	for {
-		// Skip line counting if lines is negative
+		// Skip line limit if lines is negative
 		if lines >= 0 && count >= lines {
-			return nil
+			return count, nil
 		}
 		t := time.NewTimer(time.Duration(timeout) * time.Millisecond)
 		select {
@@ -155,18 +424,27 @@ func sendResponse(conn net.Conn, resp <-chan string, lines, timeout int) error {
 				<-t.C
 			}
 			if !ok {
-				return nil
+				return count, nil
+			}
+			keep, complete := filter.accept(line)
+			if complete {
+				return count, nil
+			}
+			if !keep {
+				continue
 			}
 			// Send response line to socket connection
 			if _, err := io.WriteString(conn, line+"\n"); err != nil {
-				return err
-			}
-			if lines >= 0 {
-				count++
+				return count, err
 			}
+			count++
 		case <-t.C:
 			// Timeout exceeded
-			return nil
+			return count, nil
+		case <-done:
+			// Process output closed
+			t.Stop()
+			return count, nil
 		}
 	}
 }
@@ -180,19 +458,25 @@ func (h *handler) HandleStdin() {
 		if !ok {
 			return
 		}
-		if _, err := io.WriteString(h.Stdin, input+"\n"); err != nil {
+		h.metrics.queue(-1)
+		h.mu.Lock()
+		stdin := h.Stdin
+		h.mu.Unlock()
+		if _, err := io.WriteString(stdin, input+"\n"); err != nil {
 			log.Println(err)
 		}
 	}
 }
 
-/* goroutine: forward writes from os.Stdin to the write channel
+/* goroutine: forward writes from os.Stdin (or other terminal input) to the write channel
  *		os.Stdin -> w_chan
  */
 func (h *handler) ListenStdin() {
-	scanner := bufio.NewScanner(os.Stdin)
+	scanner := bufio.NewScanner(h.input)
 	for scanner.Scan() {
-		h.wch <- scanner.Text()
+		h.record("stdin", scanner.Text())
+		h.event(EventStdin, 0, "stdin", "", scanner.Text())
+		h.write(scanner.Text())
 	}
 	if scanner.Err() != nil {
 		log.Println(scanner.Err())
@@ -203,16 +487,30 @@ func (h *handler) ListenStdin() {
  *		cmd.Stdout -> os.Stdout + r_chan
  */
 func (h *handler) ListenStdout() {
-	go h.consumeStdout()
-	scanner := bufio.NewScanner(h.Stdout)
+	h.mu.Lock()
+	stdout, done := h.Stdout, h.done
+	h.mu.Unlock()
+
+	scanner := bufio.NewScanner(stdout)
 	for scanner.Scan() {
-		fmt.Println(scanner.Text())
+		if h.echo != nil {
+			fmt.Fprintln(h.echo, scanner.Text())
+		}
+		h.metrics.stdout()
+		h.observe(scanner.Text())
+		h.event(EventStdout, 0, "", "", scanner.Text())
 		h.rch <- scanner.Text()
 	}
-	if scanner.Err() != nil {
-		log.Println(scanner.Err())
+	// The pipe is closed when the process exits
+	if err := scanner.Err(); err != nil && !errors.Is(err, os.ErrClosed) {
+		log.Println(err)
+	}
+	h.mu.Lock()
+	if h.done == done {
+		h.stdoutOpen = false
 	}
-	close(h.rch)
+	h.mu.Unlock()
+	close(done)
 }
 
 /* goroutine: keep read channel empty when no socket connection is present
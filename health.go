@@ -0,0 +1,124 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Default interval between readiness probes
+const DefaultProbeInterval = 1000 * time.Millisecond
+
+// Health describes the state of a wrapped process.
+type Health struct {
+	// The wrapped process is running
+	Running bool `json:"running"`
+	// The process stdout pipe is open
+	StdoutOpen bool `json:"stdout_open"`
+	// The process is ready to receive commands
+	Ready bool `json:"ready"`
+}
+
+// Healthy reports whether the process is running with its stdout pipe open.
+func (h Health) Healthy() bool {
+	return h.Running && h.StdoutOpen
+}
+
+/* Readiness configures how the Handler determines that the wrapped process is ready to
+ * receive commands. If Pattern is set, the process is ready once a matching line appears
+ * on stdout. If Probe is set, it is sent to the process every Interval until a response
+ * line matches Expect (or any response is received, if Expect is nil). If neither is set,
+ * the process is ready as soon as its stdout pipe is open.
+ */
+type Readiness struct {
+	Pattern  *regexp.Regexp
+	Probe    []string
+	Expect   *regexp.Regexp
+	Interval time.Duration
+}
+
+func (h *handler) Readiness(r Readiness) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness = r
+}
+
+func (h *handler) Health() Health {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return Health{Running: h.stdoutOpen, StdoutOpen: h.stdoutOpen, Ready: h.ready && h.stdoutOpen}
+}
+
+// observe checks a line of stdout against the readiness pattern and records it.
+func (h *handler) observe(line string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tailLine(line)
+	if !h.ready && h.readiness.Pattern != nil && h.readiness.Pattern.MatchString(line) {
+		h.markReady()
+	}
+}
+
+/* goroutine: send the readiness probe to the wrapped process until it responds
+ *		probe -> w_chan, r_chan -> expect
+ */
+func (h *handler) probeReadiness() {
+	h.mu.Lock()
+	r := h.readiness
+	h.mu.Unlock()
+	if len(r.Probe) == 0 {
+		return
+	}
+	if r.Interval <= 0 {
+		r.Interval = DefaultProbeInterval
+	}
+	for {
+		time.Sleep(r.Interval)
+		if health := h.Health(); health.Ready || !health.StdoutOpen {
+			return
+		}
+		var buf bytes.Buffer
+		if _, err := h.exchange(strings.Join(r.Probe, " "), -1, 0, &buf); err != nil {
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if line != "" && (r.Expect == nil || r.Expect.MatchString(line)) {
+				h.mu.Lock()
+				h.markReady()
+				h.mu.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// healthControl reports the Handler health as a JSON object.
+func healthControl(health func() Health) ControlFunc {
+	return func(_ []string) ([]string, error) {
+		b, err := json.Marshal(health())
+		if err != nil {
+			return nil, err
+		}
+		return []string{string(b)}, nil
+	}
+}
@@ -0,0 +1,79 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+type identityKey struct{}
+
+/* WithIdentity returns a copy of the given context carrying an authenticated client
+ * identity. Authentication middleware for the WrapperAPI may use this to rate limit
+ * requests by user rather than by remote address.
+ */
+func WithIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the authenticated client identity stored in the context.
+func IdentityFromContext(ctx context.Context) (string, bool) {
+	identity, ok := ctx.Value(identityKey{}).(string)
+	return identity, ok && identity != ""
+}
+
+/* ConnIdentity returns the client identity of the given socket connection. UNIX domain
+ * socket peers are identified by user ID where the platform supports it, and all other
+ * connections are identified by remote host.
+ */
+func ConnIdentity(conn net.Conn) string {
+	if uid, _, ok := peerCred(conn); ok {
+		return "uid:" + strconv.Itoa(uid)
+	}
+	return remoteHost(conn.RemoteAddr().String())
+}
+
+// netConn unwraps the given connection to the underlying network connection.
+func netConn(conn net.Conn) net.Conn {
+	for {
+		wrapped, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return conn
+		}
+		conn = wrapped.NetConn()
+	}
+}
+
+// requestIdentity returns the client identity of the given HTTP request.
+func requestIdentity(r *http.Request) string {
+	if identity, ok := IdentityFromContext(r.Context()); ok {
+		return identity
+	}
+	return remoteHost(r.RemoteAddr)
+}
+
+func remoteHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
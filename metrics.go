@@ -0,0 +1,216 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Maximum number of distinct command label values before commands are grouped as "other"
+const MaxCommandLabels = 100
+
+// Upper bounds in seconds of the response latency histogram buckets
+var LatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+/* metrics collects Handler and Wrapper instrumentation, which is written in the Prometheus
+ * text exposition format. Only the small subset of the format needed here is implemented.
+ */
+type metrics struct {
+	mu sync.Mutex
+
+	commands      map[[2]string]uint64 // command, outcome
+	forbidden     map[string]uint64    // command
+	labels        map[string]bool
+	responseLines uint64
+	stdoutLines   uint64
+	queueDepth    int64
+	starts        uint64
+
+	latencyCount   uint64
+	latencySum     float64
+	latencyBuckets []uint64
+
+	started time.Time // zero unless the process is running
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		commands:       make(map[[2]string]uint64),
+		forbidden:      make(map[string]uint64),
+		labels:         make(map[string]bool),
+		latencyBuckets: make([]uint64, len(LatencyBuckets)),
+	}
+}
+
+// label returns the label value for the given command, limiting label cardinality.
+func (m *metrics) label(args []string) string {
+	if len(args) == 0 || args[0] == "" {
+		return ""
+	}
+	if !m.labels[args[0]] {
+		if len(m.labels) >= MaxCommandLabels {
+			return "other"
+		}
+		m.labels[args[0]] = true
+	}
+	return args[0]
+}
+
+func (m *metrics) command(args []string, outcome string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commands[[2]string{m.label(args), outcome}]++
+}
+
+func (m *metrics) forbid(args []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	label := m.label(args)
+	m.commands[[2]string{label, "forbidden"}]++
+	m.forbidden[label]++
+}
+
+func (m *metrics) response(lines int, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.responseLines += uint64(lines)
+	m.latencyCount++
+	m.latencySum += latency.Seconds()
+	for i, bound := range LatencyBuckets {
+		if latency.Seconds() <= bound {
+			m.latencyBuckets[i]++
+		}
+	}
+}
+
+func (m *metrics) stdout() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stdoutLines++
+}
+
+func (m *metrics) queue(delta int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queueDepth += delta
+}
+
+// process records the start (or exit, if running is false) of the wrapped process.
+func (m *metrics) process(running bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !running {
+		m.started = time.Time{}
+		return
+	}
+	m.starts++
+	m.started = time.Now()
+}
+
+// WriteTo writes all metrics to the given Writer in the Prometheus text format.
+func (m *metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	family := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	family("socketcmd_commands_total", "counter",
+		"Commands received, by first argument and outcome.")
+	keys := make([][2]string, 0, len(m.commands))
+	for key := range m.commands {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		fmt.Fprintf(&b, "socketcmd_commands_total{command=%s,outcome=%s} %d\n",
+			quoteLabel(key[0]), quoteLabel(key[1]), m.commands[key])
+	}
+
+	family("socketcmd_forbidden_commands_total", "counter",
+		"Attempts to send forbidden commands, by first argument.")
+	cmds := make([]string, 0, len(m.forbidden))
+	for cmd := range m.forbidden {
+		cmds = append(cmds, cmd)
+	}
+	sort.Strings(cmds)
+	for _, cmd := range cmds {
+		fmt.Fprintf(&b, "socketcmd_forbidden_commands_total{command=%s} %d\n",
+			quoteLabel(cmd), m.forbidden[cmd])
+	}
+
+	family("socketcmd_response_lines_total", "counter",
+		"Lines of output returned to socket clients.")
+	fmt.Fprintf(&b, "socketcmd_response_lines_total %d\n", m.responseLines)
+
+	family("socketcmd_response_duration_seconds", "histogram",
+		"Time from forwarding a command until its response is complete.")
+	for i, bound := range LatencyBuckets {
+		fmt.Fprintf(&b, "socketcmd_response_duration_seconds_bucket{le=\"%s\"} %d\n",
+			strconv.FormatFloat(bound, 'g', -1, 64), m.latencyBuckets[i])
+	}
+	fmt.Fprintf(&b, "socketcmd_response_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.latencyCount)
+	fmt.Fprintf(&b, "socketcmd_response_duration_seconds_sum %g\n", m.latencySum)
+	fmt.Fprintf(&b, "socketcmd_response_duration_seconds_count %d\n", m.latencyCount)
+
+	family("socketcmd_queue_depth", "gauge",
+		"Lines waiting to be written to the process stdin.")
+	fmt.Fprintf(&b, "socketcmd_queue_depth %d\n", m.queueDepth)
+
+	family("socketcmd_stdout_lines_total", "counter",
+		"Lines read from the process stdout. Use rate() for lines per second.")
+	fmt.Fprintf(&b, "socketcmd_stdout_lines_total %d\n", m.stdoutLines)
+
+	var restarts uint64
+	if m.starts > 1 {
+		restarts = m.starts - 1
+	}
+	family("socketcmd_process_restarts_total", "counter",
+		"Times the wrapped process was started after its first start.")
+	fmt.Fprintf(&b, "socketcmd_process_restarts_total %d\n", restarts)
+
+	var uptime float64
+	if !m.started.IsZero() {
+		uptime = time.Since(m.started).Seconds()
+	}
+	family("socketcmd_process_uptime_seconds", "gauge",
+		"Seconds since the wrapped process was started, or zero if it is not running.")
+	fmt.Fprintf(&b, "socketcmd_process_uptime_seconds %g\n", uptime)
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// quoteLabel quotes a label value using the escaping rules of the text format.
+func quoteLabel(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
@@ -0,0 +1,333 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrMissingTarget   = errors.New("missing socketcmd target instance")
+	ErrUnknownTarget   = errors.New("unknown socketcmd target instance")
+	ErrDuplicateTarget = errors.New("an instance with the given name already exists")
+)
+
+/* A Multiplexer hosts several named Wrappers behind a single socket. Socket clients select
+ * the instance with the target prefix of the header (e.g. "survival@-1:"), or send the
+ * command to every instance with BroadcastTarget (e.g. "*@-1:").
+ */
+type Multiplexer interface {
+	// Addr returns the address of the underlying net Listener.
+	Addr() net.Addr
+	// Add a new named Wrapper around the given command.
+	Add(name string, cmd *exec.Cmd) (Wrapper, error)
+	// Instance returns the named Wrapper.
+	Instance(name string) (Wrapper, bool)
+	// Names returns the sorted names of all instances.
+	Names() []string
+	// Start the socket listener and every instance. Instances added later start immediately.
+	Start() error
+	// Wait for every instance to exit, then close the socket listener.
+	Wait() error
+	// Broadcast sends a command to every instance, returning the responses by instance name.
+	Broadcast(header string, args ...string) map[string]InstanceResponse
+
+	// ExposeAPI for high-level network operations.
+	ExposeAPI(ParseFunc) MultiplexerAPI
+}
+
+// An InstanceResponse is the response of one Multiplexer instance to a broadcast command.
+type InstanceResponse struct {
+	Lines []string `json:"lines"`
+	Error string   `json:"error,omitempty"`
+}
+
+/* NewUnixMultiplexer returns a new Multiplexer using a new UNIX domain socket Listener with
+ * the given address.
+ */
+func NewUnixMultiplexer(socket string) (Multiplexer, error) {
+	os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	return NewMultiplexer(listener)
+}
+
+// NewMultiplexer returns a new Multiplexer using the given net Listener.
+func NewMultiplexer(listener net.Listener) (Multiplexer, error) {
+	if listener == nil {
+		return nil, errors.New("missing required parameters")
+	}
+	return &multiplexer{Socket: listener, instances: make(map[string]*muxInstance)}, nil
+}
+
+type multiplexer struct {
+	Socket net.Listener
+
+	mu        sync.Mutex
+	instances map[string]*muxInstance
+	started   bool
+}
+
+type muxInstance struct {
+	*wrapper
+	l *pipeListener
+}
+
+func (m *multiplexer) Addr() net.Addr {
+	return m.Socket.Addr()
+}
+
+func (m *multiplexer) Add(name string, cmd *exec.Cmd) (Wrapper, error) {
+	if name == "" || name == BroadcastTarget || strings.ContainsAny(name, TargetSeparator+" /") {
+		return nil, fmt.Errorf("invalid instance name: %q", name)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.instances[name]; ok {
+		return nil, ErrDuplicateTarget
+	}
+
+	// Each instance accepts connections routed from the Multiplexer's listener
+	l := newPipeListener(name)
+	w, err := New(l, cmd)
+	if err != nil {
+		return nil, err
+	}
+	inst := &muxInstance{w.(*wrapper), l}
+	m.instances[name] = inst
+
+	// Instances share the terminal output, but do not read terminal input
+	inst.h.input = nil
+	inst.h.echo = &prefixWriter{os.Stdout, "[" + name + "] "}
+	if m.started {
+		if err := w.Start(); err != nil {
+			delete(m.instances, name)
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (m *multiplexer) Instance(name string) (Wrapper, bool) {
+	inst, ok := m.instance(name)
+	if !ok {
+		return nil, false
+	}
+	return inst.wrapper, true
+}
+
+func (m *multiplexer) instance(name string) (*muxInstance, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inst, ok := m.instances[name]
+	return inst, ok
+}
+
+func (m *multiplexer) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.instances))
+	for name := range m.instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *multiplexer) Start() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, inst := range m.instances {
+		if err := inst.Start(); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	m.started = true
+	go m.HandleSocket()
+	return nil
+}
+
+func (m *multiplexer) Wait() error {
+	defer m.Socket.Close()
+	var errs []string
+	for _, name := range m.Names() {
+		inst, _ := m.instance(name)
+		if err := inst.Wait(); err != nil {
+			errs = append(errs, name+": "+err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+/* goroutine: route socket connections to the target instances
+ *		socket -> instance socket
+ */
+func (m *multiplexer) HandleSocket() {
+	for {
+		conn, err := m.Socket.Accept()
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return
+			}
+			log.Println(err)
+			continue
+		}
+		go func() {
+			if err := m.route(conn); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+}
+
+// route reads the target from the connection header and forwards it to the instance.
+func (m *multiplexer) route(conn net.Conn) error {
+	buf := make([]byte, ConnBufferSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	// target@[lines]:[timeout] args...
+	words := strings.SplitN(string(buf[:n]), " ", 2)
+	if len(words) < 2 {
+		words = append(words, "")
+	}
+	target, header := ParseTarget(words[0])
+	if target == BroadcastTarget {
+		defer conn.Close()
+		return m.broadcast(conn, header, words[1])
+	}
+	inst, ok := m.instance(target)
+	if !ok {
+		defer conn.Close()
+		err := ErrUnknownTarget
+		if target == "" {
+			err = ErrMissingTarget
+		}
+		status := &StatusError{Status: StatusUnknown, Message: err.Error() + ": " + target}
+		_, err2 := io.WriteString(conn, status.String()+"\n")
+		return err2
+	}
+	rest := []byte(header + " " + words[1])
+	if err := inst.l.deliver(context.Background(), &prefixConn{conn, rest}); err != nil {
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+// broadcast sends a command to every instance, prefixing response lines by name.
+func (m *multiplexer) broadcast(conn net.Conn, header, cmd string) error {
+	responses := m.broadcastAs(conn, "", header, cmd)
+	for _, name := range m.Names() {
+		resp, ok := responses[name]
+		if !ok {
+			continue
+		}
+		for _, line := range resp.Lines {
+			if _, err := io.WriteString(conn, "["+name+"] "+line+"\n"); err != nil {
+				return err
+			}
+		}
+		if resp.Error != "" {
+			if _, err := io.WriteString(conn, "["+name+"] "+resp.Error+"\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *multiplexer) Broadcast(header string, args ...string) map[string]InstanceResponse {
+	return m.broadcastAs(nil, "", header, args...)
+}
+
+/* broadcastAs sends a command to every instance on behalf of a client, so that each
+ * instance identifies and rate limits it as that client. The client is either a socket
+ * connection, or the identity of an HTTP client which is checked against the rate limiter
+ * of each instance before the command is sent to it.
+ */
+func (m *multiplexer) broadcastAs(peer net.Conn, identity, header string, args ...string,
+) map[string]InstanceResponse {
+	names := m.Names()
+	results := make([]InstanceResponse, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		inst, _ := m.instance(name)
+		if limiter := inst.h.limiter; identity != "" && limiter != nil {
+			if ok, wait := limiter.Allow(identity, args); !ok {
+				inst.h.metrics.command(args, "rate_limited")
+				results[i].Error = (&StatusError{StatusRateLimited, "too many commands", wait}).Error()
+				continue
+			}
+		}
+		dial := func(ctx context.Context) (net.Conn, error) {
+			return inst.l.dialAs(ctx, peer)
+		}
+		wg.Add(1)
+		go func(i int, c *client) {
+			defer wg.Done()
+			conn, err := c.dialContext(context.Background())
+			if err == nil {
+				defer conn.Close()
+				results[i].Lines, err = c.send(conn, header, args...)
+			}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i, &client{dial: dial})
+	}
+	wg.Wait()
+
+	responses := make(map[string]InstanceResponse, len(names))
+	for i, name := range names {
+		responses[name] = results[i]
+	}
+	return responses
+}
+
+// A prefixWriter prefixes each write (a single line of output) with a fixed string.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	if _, err := io.WriteString(p.w, p.prefix+string(b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
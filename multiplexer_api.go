@@ -0,0 +1,218 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+/* A MultiplexerAPI extends an enclosed Multiplexer with high-level remote API operations.
+ * The endpoints of each instance's WrapperAPI are served under "/instances/{name}/", e.g.
+ * "/instances/{name}/command" and "/instances/{name}/healthz".
+ */
+type MultiplexerAPI interface {
+	Multiplexer
+	/* ServeHTTP routes requests to the instance endpoints at "/instances/" and the broadcast
+	 * endpoint at "/broadcast", through the middleware of the MultiplexerAPI.
+	 */
+	http.Handler
+	/* Listen on the given address and serve the MultiplexerAPI. The address is either a TCP
+	 * address or "unix:" followed by the path of a Unix domain socket. Listen returns
+	 * http.ErrServerClosed after Shutdown.
+	 */
+	Listen(addr string) error
+	// ListenTLS listens on the given address like Listen, and serves HTTPS.
+	ListenTLS(addr, certFile, keyFile string) error
+	// Serve the MultiplexerAPI on the given listener.
+	Serve(net.Listener) error
+	/* Shutdown gracefully shuts down the HTTP servers of the MultiplexerAPI, waiting for
+	 * active requests to complete until the context is done.
+	 */
+	Shutdown(context.Context) error
+	// Use adds middleware to the MultiplexerAPI. The first middleware added is the outermost.
+	Use(...Middleware)
+	// Server sets the configuration of the HTTP servers started by the MultiplexerAPI.
+	Server(*http.Server)
+	/* Instances endpoint for the MultiplexerAPI. Requests for "/instances" respond with the
+	 * status of every instance as a JSON object keyed by name. Requests for an instance
+	 * path are dispatched to the corresponding endpoint of the instance's WrapperAPI, and
+	 * commands may be posted to "/instances/{name}/" itself.
+	 */
+	InstancesEndpoint(http.ResponseWriter, *http.Request)
+	/* Broadcast endpoint for the MultiplexerAPI. This endpoint expects a command sequence in
+	 * one of the formats accepted by WrapperAPI.CommandEndpoint, and responds with a JSON object of
+	 * InstanceResponses keyed by instance name.
+	 */
+	BroadcastEndpoint(http.ResponseWriter, *http.Request)
+	/* UI enables the web console of every instance, at "/instances/{name}/ui/". See
+	 * WrapperAPI.UI.
+	 */
+	UI(enabled bool)
+}
+
+func (m *multiplexer) ExposeAPI(parser ParseFunc) MultiplexerAPI {
+	if parser == nil {
+		parser = DefaultParseFunc
+	}
+	api := &multiplexerAPI{
+		multiplexer: m,
+		apiServer:   newAPIServer(),
+		parser:      parser,
+		apis:        make(map[string]WrapperAPI),
+	}
+	api.handle("/instances", api.InstancesEndpoint)
+	api.handle("/instances/", api.InstancesEndpoint)
+	api.handle("/broadcast", api.BroadcastEndpoint)
+	return api
+}
+
+type multiplexerAPI struct {
+	*multiplexer
+	*apiServer
+	parser ParseFunc
+
+	apiMu sync.Mutex
+	apis  map[string]WrapperAPI
+	ui    bool
+}
+
+func (api *multiplexerAPI) Listen(addr string) error {
+	return api.listenAndServe(addr, "", "")
+}
+
+func (api *multiplexerAPI) ListenTLS(addr, certFile, keyFile string) error {
+	return api.listenAndServe(addr, certFile, keyFile)
+}
+
+// instanceAPI returns the WrapperAPI of the named instance.
+func (api *multiplexerAPI) instanceAPI(name string) (WrapperAPI, bool) {
+	w, ok := api.Instance(name)
+	if !ok {
+		return nil, false
+	}
+	api.apiMu.Lock()
+	defer api.apiMu.Unlock()
+	if _, ok := api.apis[name]; !ok {
+		api.apis[name] = w.ExposeAPI(api.parser)
+		api.apis[name].UI(api.ui)
+	}
+	return api.apis[name], true
+}
+
+func (api *multiplexerAPI) UI(enabled bool) {
+	api.apiMu.Lock()
+	defer api.apiMu.Unlock()
+	api.ui = enabled
+	for _, inst := range api.apis {
+		inst.UI(enabled)
+	}
+}
+
+func (api *multiplexerAPI) InstancesEndpoint(w http.ResponseWriter, r *http.Request) {
+	// /instances/{name}/{endpoint}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/instances"), "/")
+	if path == "" {
+		statuses := make(map[string]ProcessStatus)
+		for _, name := range api.Names() {
+			if inst, ok := api.Instance(name); ok {
+				statuses[name] = inst.Status()
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(statuses); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	parts := strings.SplitN(path, "/", 2)
+	inst, ok := api.instanceAPI(parts[0])
+	if !ok {
+		handlerErr(w, ErrUnknownTarget, http.StatusNotFound)
+		return
+	}
+	endpoint := ""
+	if len(parts) > 1 {
+		endpoint = parts[1]
+	}
+	switch endpoint {
+	case "", "status":
+		// Commands may be posted to the instance path, as to the root of a WrapperAPI
+		if endpoint == "" && r.Method == http.MethodPost {
+			inst.CommandEndpoint(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(inst.Status()); err != nil {
+			log.Println(err)
+		}
+	case "command":
+		inst.CommandEndpoint(w, r)
+	default:
+		// Other endpoints are routed by the instance's WrapperAPI
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/" + endpoint
+		inst.ServeHTTP(w, r2)
+	}
+}
+
+func (api *multiplexerAPI) BroadcastEndpoint(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	// Parse command sequence from request body
+	limitBody(w, r)
+	reqs, err := readCommands(r)
+	if err != nil {
+		bodyErr(w, err)
+		return
+	}
+	if len(reqs) > 1 {
+		handlerErr(w, errors.New("only one command may be broadcast"), http.StatusBadRequest)
+		return
+	}
+
+	// Use the given header if present, otherwise generate one with the parser
+	args := reqs[0].Command
+	header := api.parser(args)
+	if header == ForbiddenHeader {
+		log.Printf("attempted forbidden command: %v\n", args)
+		handlerErr(w, ErrCommandForbidden, http.StatusForbidden)
+		return
+	}
+	if h := reqs[0].header(); h != "" {
+		header = h
+	}
+
+	// Each instance applies its rate limit to the requesting client
+	responses := api.broadcastAs(nil, requestIdentity(r), header, args...)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(responses); err != nil {
+		handlerErr(w, err, http.StatusInternalServerError)
+		return
+	}
+}
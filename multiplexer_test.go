@@ -0,0 +1,110 @@
+//go:build unix
+
+package socketcmd_test
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+	"github.com/faceless-saint/go-socketcmd/socketcmdtest"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* newMultiplexer starts a Multiplexer with a single instance "a" running cat, which allows
+ * one command per client.
+ */
+func newMultiplexer(t *testing.T) (socketcmd.Multiplexer, socketcmd.Client) {
+	ln := socketcmdtest.Listen(t)
+	m, err := socketcmd.NewMultiplexer(ln)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := m.Add("a", socketcmd.Cmd("cat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Limit(socketcmd.NewRateLimiter(socketcmd.RateLimit{Rate: 0.01, Burst: 1}, nil))
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		w.Stop()
+		m.Wait()
+	})
+	c := socketcmdtest.NewClient(ln)
+	c.Policy(&socketcmd.Argument{Header: "1:"})
+	return m, c
+}
+
+func TestBroadcastRateLimit(t *testing.T) {
+	_, c := newMultiplexer(t)
+
+	// A broadcast counts against the limit of the client which sent it
+	lines, err := c.Target(socketcmd.BroadcastTarget).Send("one")
+	expect(t, lines, err, "[a] one")
+	lines, err = c.Target(socketcmd.BroadcastTarget).Send("two")
+	if err != nil || len(lines) != 1 || !strings.Contains(lines[0], socketcmd.StatusRateLimited) {
+		t.Fatalf("second broadcast = %q, %v; want a rate-limited response", lines, err)
+	}
+	_, err = c.Target("a").Send("three")
+	expectStatus(t, err, socketcmd.StatusRateLimited)
+}
+
+func TestBroadcastEndpoint(t *testing.T) {
+	m, _ := newMultiplexer(t)
+	srv := httptest.NewServer(m.ExposeAPI(func(args []string) string {
+		if args[0] == "stop" {
+			return socketcmd.ForbiddenHeader
+		}
+		return "1:10000"
+	}))
+	t.Cleanup(srv.Close)
+	ctx := contextTimeout(t, 5*time.Second)
+
+	// Forbidden commands are refused as by the command endpoint
+	if status, err := post(t, ctx, srv.URL+"/broadcast", `["stop"]`); err != nil || status != http.StatusForbidden {
+		t.Fatalf("forbidden broadcast = %d, %v; want %d", status, err, http.StatusForbidden)
+	}
+
+	// The rate limit of each instance applies to the HTTP client
+	broadcast := func(cmd string) socketcmd.InstanceResponse {
+		resp, err := http.Post(srv.URL+"/broadcast", "application/json", strings.NewReader(`["`+cmd+`"]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var responses map[string]socketcmd.InstanceResponse
+		if err := json.NewDecoder(resp.Body).Decode(&responses); err != nil {
+			t.Fatal(err)
+		}
+		return responses["a"]
+	}
+	if resp := broadcast("one"); resp.Error != "" || len(resp.Lines) != 1 || resp.Lines[0] != "one" {
+		t.Fatalf("first broadcast = %+v; want [one]", resp)
+	}
+	if resp := broadcast("two"); !strings.Contains(resp.Error, socketcmd.StatusRateLimited) {
+		t.Fatalf("second broadcast = %+v; want a rate-limited error", resp)
+	}
+}
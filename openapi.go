@@ -0,0 +1,303 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Path prefix of the typed command routes of a WrapperAPI.
+const CommandsPath = "/commands/"
+
+/* A commandRoute is a typed API route for a command in the Policy's command tree. Every
+ * allowed command which defines parameters, or has no subcommands, is given a route.
+ */
+type commandRoute struct {
+	path []string
+	arg  Argument
+}
+
+// commandRoutes returns the typed routes of the given command tree, sorted by path.
+func commandRoutes(root Argument) []commandRoute {
+	var routes []commandRoute
+	var walk func(path []string, a Argument, header string)
+	walk = func(path []string, a Argument, header string) {
+		if a.Header != "" {
+			header = a.Header
+		}
+		if len(path) > 0 && header != ForbiddenHeader && (a.Params != nil || len(a.Args) == 0) {
+			routes = append(routes, commandRoute{append([]string(nil), path...), a})
+		}
+		names := make([]string, 0, len(a.Args))
+		for name := range a.Args {
+			// Arguments which cannot be a path segment are not routed
+			if name != "" && !strings.ContainsAny(name, "/?#% ") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			walk(append(path, name), a.Args[name], header)
+		}
+	}
+	walk(nil, root, DefaultHeader)
+	return routes
+}
+
+/* findRoute returns the typed route for the given path, if there is one, following the
+ * path segments down the command tree under the same rules as commandRoutes.
+ */
+func findRoute(root Argument, path string) (commandRoute, bool) {
+	if path == "" {
+		return commandRoute{}, false
+	}
+	segments := strings.Split(path, "/")
+	header, a := root.Header, root
+	if header == "" {
+		header = DefaultHeader
+	}
+	for _, name := range segments {
+		next, ok := a.Args[name]
+		if !ok || name == "" || strings.ContainsAny(name, "/?#% ") {
+			return commandRoute{}, false
+		}
+		if next.Header != "" {
+			header = next.Header
+		}
+		a = next
+	}
+	if header == ForbiddenHeader || (a.Params == nil && len(a.Args) > 0) {
+		return commandRoute{}, false
+	}
+	return commandRoute{segments, a}, true
+}
+
+// args returns the command sequence for the given parameter values.
+func (route commandRoute) args(values map[string]interface{}) ([]string, error) {
+	args := append([]string(nil), route.path...)
+	known := make(map[string]bool, len(route.arg.Params))
+	omitted := ""
+	for i, p := range route.arg.Params {
+		known[p.Name] = true
+		v, ok := values[p.Name]
+		if !ok || v == nil {
+			if !p.Optional {
+				return nil, errors.New("missing parameter: " + p.Name)
+			}
+			omitted = p.Name
+			continue
+		}
+		if omitted != "" {
+			return nil, fmt.Errorf("parameter %s requires omitted parameter %s", p.Name, omitted)
+		}
+		s, err := paramString(p, v)
+		if err != nil {
+			return nil, err
+		}
+		if strings.ContainsAny(s, "\r\n") ||
+			(i < len(route.arg.Params)-1 && strings.ContainsAny(s, " \t")) {
+			return nil, errors.New("invalid whitespace in parameter: " + p.Name)
+		}
+		args = append(args, s)
+	}
+	for name := range values {
+		if !known[name] {
+			return nil, errors.New("unknown parameter: " + name)
+		}
+	}
+	return args, nil
+}
+
+// paramString formats a JSON parameter value as a command argument.
+func paramString(p Param, v interface{}) (string, error) {
+	switch p.Type {
+	case "integer":
+		if f, ok := v.(float64); ok && f == float64(int64(f)) {
+			return strconv.FormatInt(int64(f), 10), nil
+		}
+	case "number":
+		if f, ok := v.(float64); ok {
+			return strconv.FormatFloat(f, 'f', -1, 64), nil
+		}
+	case "boolean":
+		if b, ok := v.(bool); ok {
+			return strconv.FormatBool(b), nil
+		}
+	case "", "string":
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	default:
+		return "", fmt.Errorf("unknown type %s of parameter %s", p.Type, p.Name)
+	}
+	return "", fmt.Errorf("parameter %s must be of type %s", p.Name, paramType(p))
+}
+
+func paramType(p Param) string {
+	if p.Type == "" {
+		return "string"
+	}
+	return p.Type
+}
+
+// commands returns the command tree of the Wrapper's Policy.
+func (api *wrapperAPI) commands() Argument {
+	api.h.mu.Lock()
+	defer api.h.mu.Unlock()
+	if api.h.policy == nil {
+		return Argument{}
+	}
+	return api.h.policy.Commands
+}
+
+func (api *wrapperAPI) CommandsEndpoint(w http.ResponseWriter, r *http.Request) {
+	route, ok := findRoute(api.commands(), strings.Trim(strings.TrimPrefix(r.URL.Path, CommandsPath), "/"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	format, ok := negotiate(r)
+	if !ok {
+		handlerErr(w, ErrNotAcceptable, http.StatusNotAcceptable)
+		return
+	}
+
+	// Parse parameter values from request body, which may be empty
+	limitBody(w, r)
+	values := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil && err != io.EOF {
+		bodyErr(w, err)
+		return
+	}
+	args, err := route.args(values)
+	if err != nil {
+		handlerErr(w, err, http.StatusBadRequest)
+		return
+	}
+	api.command(w, r, []commandRequest{{Command: args}}, format)
+}
+
+func (api *wrapperAPI) OpenAPIEndpoint(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(openAPIDocument(api.commands())); err != nil {
+		log.Println(err)
+	}
+}
+
+type jsonObject map[string]interface{}
+
+// openAPIDocument describes the typed routes of the given command tree in OpenAPI 3.
+func openAPIDocument(root Argument) jsonObject {
+	lines := jsonObject{"type": "array", "items": jsonObject{"type": "string"}}
+	text := jsonObject{"text/plain": jsonObject{"schema": jsonObject{"type": "string"}}}
+	paths := jsonObject{}
+	for _, route := range commandRoutes(root) {
+		content := jsonObject{"application/json": jsonObject{"schema": lines}}
+		if route.arg.Extract != nil {
+			content["application/json; structured"] = jsonObject{"schema": extractorSchema(route.arg.Extract)}
+		}
+		op := jsonObject{
+			"operationId": strings.Join(route.path, "_"),
+			"summary":     strings.Join(route.path, " "),
+			"responses": jsonObject{
+				"200": jsonObject{"description": "Response lines", "content": content},
+				"400": jsonObject{"description": "Invalid parameters", "content": text},
+				"403": jsonObject{"description": "Command forbidden by policy", "content": text},
+				"429": jsonObject{"description": "Rate limited", "content": text},
+				"503": jsonObject{"description": "Process starting or exited", "content": text},
+			},
+		}
+		if route.arg.Description != "" {
+			op["description"] = route.arg.Description
+		}
+		if len(route.arg.Params) > 0 {
+			op["requestBody"] = jsonObject{
+				"required": true,
+				"content":  jsonObject{"application/json": jsonObject{"schema": paramsSchema(route.arg.Params)}},
+			}
+		}
+		paths[CommandsPath+strings.Join(route.path, "/")] = jsonObject{"post": op}
+	}
+	return jsonObject{
+		"openapi": "3.0.3",
+		"info":    jsonObject{"title": "socketcmd", "version": "1"},
+		"paths":   paths,
+	}
+}
+
+func paramsSchema(params []Param) jsonObject {
+	props, required := jsonObject{}, []string{}
+	for _, p := range params {
+		schema := jsonObject{"type": paramType(p)}
+		if p.Description != "" {
+			schema["description"] = p.Description
+		}
+		props[p.Name] = schema
+		if !p.Optional {
+			required = append(required, p.Name)
+		}
+	}
+	schema := jsonObject{"type": "object", "properties": props, "additionalProperties": false}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// extractorSchema describes the object returned by the given Extractor.
+func extractorSchema(e *Extractor) jsonObject {
+	props := jsonObject{}
+	if re, err := e.compile(); err == nil {
+		for _, name := range re.SubexpNames() {
+			if name == "" {
+				continue
+			}
+			switch e.Types[name] {
+			case "int":
+				props[name] = jsonObject{"type": "integer"}
+			case "float":
+				props[name] = jsonObject{"type": "number"}
+			case "bool":
+				props[name] = jsonObject{"type": "boolean"}
+			case "list":
+				props[name] = jsonObject{"type": "array", "items": jsonObject{"type": "string"}}
+			default:
+				props[name] = jsonObject{"type": "string"}
+			}
+		}
+	}
+	item := jsonObject{"type": "object", "properties": props}
+	if !e.Multiple {
+		return item
+	}
+	return jsonObject{"type": "object", "properties": jsonObject{
+		"items": jsonObject{"type": "array", "items": item},
+	}}
+}
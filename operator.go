@@ -0,0 +1,329 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* AttachCommand attaches a socket connection to the wrapped process as an operator, like the
+ * terminal of the Wrapper. Each line the operator sends is written to the process stdin, and
+ * the process output, the input of the other operators and any replies to the operator's own
+ * input are sent to it as JSON lines, in the format of a session transcript. The optional
+ * argument names the operator (e.g. "!attach alice"), which defaults to the identity of the
+ * connection. A given name is shown with the connection identity, e.g. "alice (uid:1000)".
+ * The attachment is acknowledged with a SessionEnd line.
+ *
+ * Input is authorized, rate limited and held by the Gate as a command, and recorded in the
+ * command history, log and transcript with the operator's name. In attached mode, LockCommand and
+ * UnlockCommand take and release the exclusive write lock, which refuses the input of the
+ * other operators while it is held.
+ */
+const AttachCommand = ControlPrefix + "attach"
+
+// Control commands taking and releasing the write lock of an attached operator
+const (
+	LockCommand   = ControlPrefix + "lock"
+	UnlockCommand = ControlPrefix + "unlock"
+)
+
+// Input of an attached operator is refused while another operator holds the write lock
+const StatusLocked = "locked"
+
+// Kinds of event describing attached operators
+const (
+	EventAttach EventKind = "attach"
+	EventDetach EventKind = "detach"
+	EventLock   EventKind = "lock"
+	EventUnlock EventKind = "unlock"
+	// Reply to the input of an attached operator, sent only to that operator
+	EventStatus EventKind = "status"
+)
+
+// Kinds of event sent to attached operators
+var operatorEvents = []EventKind{
+	EventStdout, EventStdin, EventExit, EventAttach, EventDetach, EventLock, EventUnlock,
+}
+
+/* handleAttach attaches the connection as an operator until the client closes it. The
+ * operator's own input is not sent back to it.
+ */
+func (h *handler) handleAttach(conn net.Conn, id uint64, args []string, inSession bool) error {
+	if inSession {
+		status := &StatusError{Status: StatusFailed, Message: "cannot attach in a session"}
+		_, err := io.WriteString(conn, status.String()+"\n")
+		return err
+	}
+	/* The name given by the operator cannot be verified, so it is always shown with the
+	 * connection identity, which can.
+	 */
+	name := ConnIdentity(conn)
+	if len(args) > 0 && args[0] != name {
+		name = args[0] + " (" + name + ")"
+	}
+	events, cancel := h.subscribe(operatorEvents...)
+	defer cancel()
+	h.mu.Lock()
+	if h.operators == nil {
+		h.operators = make(map[uint64]string)
+	}
+	h.operators[id] = name
+	h.mu.Unlock()
+	defer h.detachOperator(id, name)
+	if _, err := io.WriteString(conn, SessionEnd+"\n"); err != nil {
+		return err
+	}
+	h.event(EventAttach, id, name, "", "")
+
+	// Input is read until the client closes the connection, and replies sent with the events
+	replies := make(chan Event)
+	closed, done := make(chan struct{}), make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(closed)
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			status := h.operatorInput(conn, id, name, strings.TrimSuffix(scanner.Text(), "\r"))
+			if status == nil {
+				continue
+			}
+			select {
+			case replies <- Event{Time: time.Now(), Kind: EventStatus, Conn: id, Line: status.String()}:
+			case <-done:
+				return
+			}
+		}
+	}()
+	enc := json.NewEncoder(conn)
+	for {
+		var e Event
+		select {
+		case e = <-events:
+			if e.Kind == EventStdin && e.Conn == id {
+				continue
+			}
+		case e = <-replies:
+		case <-closed:
+			return nil
+		}
+		if err := enc.Encode(e); err != nil {
+			return nil
+		}
+	}
+}
+
+/* operatorInput handles a line of input from an attached operator, returning the status
+ * with which it was refused, if any.
+ */
+func (h *handler) operatorInput(conn net.Conn, id uint64, name, line string) *StatusError {
+	args := strings.Fields(line)
+	if len(args) > 0 && strings.HasPrefix(args[0], ControlPrefix) {
+		if !h.authorized(args) {
+			h.metrics.forbid(args)
+			return &StatusError{Status: StatusForbidden, Message: ErrCommandForbidden.Error()}
+		}
+		switch args[0] {
+		case LockCommand:
+			return h.lockInput(id, name)
+		case UnlockCommand:
+			return h.unlockInput(id, name)
+		}
+		return &StatusError{Status: StatusUnknown, Message: args[0] + " (while attached)"}
+	}
+
+	h.mu.Lock()
+	holder, locked := h.operators[h.lockHolder]
+	locked = locked && h.lockHolder != id
+	h.mu.Unlock()
+	if locked {
+		return &StatusError{Status: StatusLocked, Message: holder + " holds the write lock"}
+	}
+	if ok, wait := h.allow(conn, args); !ok {
+		h.metrics.command(args, "rate_limited")
+		return &StatusError{StatusRateLimited, "too many commands", wait}
+	}
+	if !h.authorized(args) {
+		h.metrics.forbid(args)
+		log.Printf("(%s) attempted forbidden command: %s\n", name, line)
+		return &StatusError{Status: StatusForbidden, Message: ErrCommandForbidden.Error()}
+	}
+	if status, _, exited := h.exited(); exited {
+		return status
+	}
+	// Hold the input until the process is ready, as for commands
+	if err := h.waitGate(); err != nil {
+		return err.(*StatusError)
+	}
+	h.metrics.command(args, "ok")
+	log.Printf("(%s)-> %s\n", name, line)
+	h.record(name, line)
+	h.event(EventStdin, id, name, "", line)
+
+	// Input is not written in the middle of the exchange of a command
+	h.xmu.Lock()
+	h.write(line)
+	h.xmu.Unlock()
+	return nil
+}
+
+// lockInput gives the write lock to the operator, unless another operator holds it.
+func (h *handler) lockInput(id uint64, name string) *StatusError {
+	h.mu.Lock()
+	if holder, ok := h.operators[h.lockHolder]; ok && h.lockHolder != id {
+		h.mu.Unlock()
+		return &StatusError{Status: StatusLocked, Message: holder + " holds the write lock"}
+	}
+	h.lockHolder = id
+	h.mu.Unlock()
+	h.event(EventLock, id, name, "", "")
+	return nil
+}
+
+// unlockInput releases the write lock held by the operator.
+func (h *handler) unlockInput(id uint64, name string) *StatusError {
+	h.mu.Lock()
+	if h.lockHolder != id {
+		h.mu.Unlock()
+		return &StatusError{Status: StatusFailed, Message: "not holding the write lock"}
+	}
+	h.lockHolder = 0
+	h.mu.Unlock()
+	h.event(EventUnlock, id, name, "", "")
+	return nil
+}
+
+// detachOperator removes the operator, releasing the write lock if it holds it.
+func (h *handler) detachOperator(id uint64, name string) {
+	h.mu.Lock()
+	delete(h.operators, id)
+	unlock := h.lockHolder == id
+	if unlock {
+		h.lockHolder = 0
+	}
+	h.mu.Unlock()
+	if unlock {
+		h.event(EventUnlock, id, name, "", "")
+	}
+	h.event(EventDetach, id, name, "", "")
+}
+
+/* An Attachment is a connection attached to a Wrapper as an operator (see AttachCommand).
+ */
+type Attachment interface {
+	/* Events returns the output of the wrapped process, the input of the other operators
+	 * and the replies to this operator's input. The channel is closed when the attachment
+	 * ends.
+	 */
+	Events() <-chan Event
+	// Input writes a line of input to the wrapped process.
+	Input(line string) error
+	// Lock takes the write lock, which refuses the input of the other operators.
+	Lock() error
+	// Unlock releases the write lock.
+	Unlock() error
+	// Close detaches from the wrapped process.
+	Close() error
+}
+
+func (c *client) Attach(ctx context.Context, name string) (Attachment, error) {
+	if strings.ContainsAny(name, " \n") {
+		return nil, errors.New("invalid operator name: " + name)
+	}
+	conn, err := c.dialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := &session{conn: conn, r: bufio.NewReader(conn)}
+	header := TargetHeader(c.Instance, DefaultHeader)
+	if _, err := s.roundTrip(ctx, strings.TrimSpace(header+" "+AttachCommand+" "+name)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	a := &attachment{conn: conn, events: make(chan Event)}
+	// The connection is closed when the context is done, until the reader exits
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	go func() {
+		defer close(a.events)
+		defer close(done)
+		defer conn.Close()
+		dec := json.NewDecoder(s.r)
+		for {
+			var e Event
+			if err := dec.Decode(&e); err != nil {
+				return
+			}
+			select {
+			case a.events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return a, nil
+}
+
+type attachment struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	events chan Event
+}
+
+func (a *attachment) Events() <-chan Event {
+	return a.events
+}
+
+func (a *attachment) Input(line string) error {
+	if strings.Contains(line, "\n") {
+		return ErrNewline
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err := io.WriteString(a.conn, line+"\n")
+	return err
+}
+
+func (a *attachment) Lock() error {
+	return a.Input(LockCommand)
+}
+
+func (a *attachment) Unlock() error {
+	return a.Input(UnlockCommand)
+}
+
+func (a *attachment) Close() error {
+	return a.conn.Close()
+}
@@ -0,0 +1,189 @@
+package socketcmd_test
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+	"github.com/faceless-saint/go-socketcmd/socketcmdtest"
+
+	"context"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// operators allows clients to attach as operators and take the write lock.
+var operators = allowControl(socketcmd.AttachCommand, socketcmd.LockCommand, socketcmd.UnlockCommand)
+
+// nextEvent returns the next event of the given kind received by the Attachment.
+func nextEvent(t *testing.T, a socketcmd.Attachment, kind socketcmd.EventKind) socketcmd.Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-a.Events():
+			if !ok {
+				t.Fatalf("attachment closed waiting for a %s event", kind)
+			}
+			if e.Kind == kind {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for a %s event", kind)
+		}
+	}
+}
+
+// waitInputs waits until the process has read the given inputs.
+func waitInputs(t *testing.T, s *socketcmdtest.Script, want ...string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !reflect.DeepEqual(s.Current().Inputs(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Inputs() = %q, want %q", s.Current().Inputs(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	s := socketcmdtest.NewScript().On("say hi", "[Server] hi")
+	_, c := newWrapper(t, s, operators)
+	ctx := contextTimeout(t, 10*time.Second)
+
+	alice, err := c.Attach(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.Close()
+	bob, err := c.Attach(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.Close()
+	nextEvent(t, alice, socketcmd.EventAttach)
+
+	if err := alice.Input("say hi"); err != nil {
+		t.Fatal(err)
+	}
+	// The given name cannot be verified, so it is shown with the connection identity
+	e := nextEvent(t, bob, socketcmd.EventStdin)
+	if e.Line != "say hi" || !strings.HasPrefix(e.Source, "alice (") {
+		t.Errorf("input event %+v, want %q from alice with its identity", e, "say hi")
+	}
+	// Both operators see the output, and not their own input
+	for _, a := range []socketcmd.Attachment{alice, bob} {
+		if e := nextEvent(t, a, socketcmd.EventStdout); e.Line != "[Server] hi" {
+			t.Errorf("output event %+v, want %q", e, "[Server] hi")
+		}
+	}
+	select {
+	case e := <-alice.Events():
+		if e.Kind == socketcmd.EventStdin {
+			t.Errorf("alice received its own input: %+v", e)
+		}
+	default:
+	}
+	waitInputs(t, s, "say hi")
+}
+
+func TestAttachLock(t *testing.T) {
+	s := socketcmdtest.NewScript()
+	_, c := newWrapper(t, s, operators)
+	ctx := contextTimeout(t, 10*time.Second)
+
+	alice, err := c.Attach(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.Close()
+	bob, err := c.Attach(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.Close()
+
+	alice.Lock()
+	nextEvent(t, bob, socketcmd.EventLock)
+	bob.Input("refused")
+	e := nextEvent(t, bob, socketcmd.EventStatus)
+	if status, ok := socketcmd.ParseStatus(e.Line); !ok || status.Status != socketcmd.StatusLocked {
+		t.Errorf("reply %q, want a %q status", e.Line, socketcmd.StatusLocked)
+	}
+	alice.Input("allowed")
+	waitInputs(t, s, "allowed")
+
+	// The lock is released by Unlock, or when its holder detaches
+	alice.Unlock()
+	nextEvent(t, bob, socketcmd.EventUnlock)
+	bob.Input("unlocked")
+	waitInputs(t, s, "allowed", "unlocked")
+
+	alice.Lock()
+	nextEvent(t, bob, socketcmd.EventLock)
+	alice.Close()
+	nextEvent(t, bob, socketcmd.EventUnlock)
+	bob.Input("detached")
+	waitInputs(t, s, "allowed", "unlocked", "detached")
+}
+
+func TestAttachGate(t *testing.T) {
+	s := readyScript()
+	_, c := newWrapper(t, s, operators, readiness(socketcmd.Gate{}))
+	ctx := contextTimeout(t, 10*time.Second)
+
+	alice, err := c.Attach(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.Close()
+
+	// Input is held until the process is ready, like a command
+	alice.Input("early")
+	time.Sleep(startup / 2)
+	if inputs := s.Current().Inputs(); len(inputs) > 0 {
+		t.Errorf("process read %q before it was ready", inputs)
+	}
+	waitInputs(t, s, "early")
+}
+
+func TestAttachClose(t *testing.T) {
+	_, c := newWrapper(t, socketcmdtest.NewScript(), operators)
+	before := runtime.NumGoroutine()
+
+	// Attachments closed without cancelling their context leave no goroutines behind
+	for i := 0; i < 20; i++ {
+		a, err := c.Attach(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Close()
+		for range a.Events() {
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before+5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines after closing the attachments, %d before", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -37,6 +37,12 @@ var (
 
 	headerRegexp = regexp.MustCompile("^-?[0-9]*:[0-9]*$")
 
+	// Separates the Multiplexer instance name from the rest of the header
+	TargetSeparator = "@"
+
+	// Target name which addresses every instance of a Multiplexer
+	BroadcastTarget = "*"
+
 	ErrMissingHeader    = fmt.Errorf("missing or invalid socketcmd header")
 	ErrCommandForbidden = fmt.Errorf("the provided command is not allowed")
 )
@@ -58,6 +64,22 @@ func Header(lines, timeout int) string {
 	return fmt.Sprintf("%d:%d", lines, timeout)
 }
 
+// TargetHeader representation of the given header addressed to the named instance.
+func TargetHeader(target, header string) string {
+	if target == "" {
+		return header
+	}
+	return target + TargetSeparator + header
+}
+
+// ParseTarget splits the instance name from the given header.
+func ParseTarget(header string) (target, rest string) {
+	if i := strings.Index(header, TargetSeparator); i >= 0 {
+		return header[:i], header[i+len(TargetSeparator):]
+	}
+	return "", header
+}
+
 // ParseHeader extracts the line count and timeout from the given header.
 func ParseHeader(header string) (lines, timeout int, err error) {
 	if !headerRegexp.MatchString(header) {
@@ -84,37 +106,109 @@ func DefaultParseFunc(_ []string) string {
 }
 
 func NewArguments(table map[string]string, defaultHeader string) Argument {
-	a := Argument{make(map[string]Argument, len(table)), defaultHeader}
+	a := Argument{Args: make(map[string]Argument, len(table)), Header: defaultHeader}
 	for cmd, header := range table {
-		a.Args[cmd] = Argument{nil, header}
+		a.Args[cmd] = Argument{Header: header}
 	}
 	return a
 }
 
 type Argument struct {
-	Args   map[string]Argument
-	Header string
+	Args   map[string]Argument `json:"args,omitempty"`
+	Header string              `json:"header,omitempty"`
+	// Optional rate limit for this command and its subcommands
+	Limit *RateLimit `json:"limit,omitempty"`
+	// Whether this command and its subcommands may safely be sent more than once
+	Idempotent *bool `json:"idempotent,omitempty"`
+	// Optional pattern matching the lines of output which belong to the command's response
+	Response string `json:"response,omitempty"`
+	// Optional extractor turning the command's response into a JSON object
+	Extract *Extractor `json:"extract,omitempty"`
+	// Optional description and parameters of the command, for typed API routes
+	Description string  `json:"description,omitempty"`
+	Params      []Param `json:"params,omitempty"`
 }
 
-func (a *Argument) Match(args []string) string {
-	// Use default if header is omitted
-	if a.Header == "" {
-		a.Header = DefaultHeader
-	}
-	if len(args) < 1 {
-		return a.Header
+/* A Param describes a parameter of a command, which follows the command's arguments. The
+ * type is one of "string" (the default), "integer", "number" or "boolean". Only the last
+ * parameter of a command may contain spaces.
+ */
+type Param struct {
+	Name        string `json:"name"`
+	Type        string `json:"type,omitempty"`
+	Description string `json:"description,omitempty"`
+	Optional    bool   `json:"optional,omitempty"`
+}
+
+/* walk calls the given function for the argument and then for each subcommand matched by the
+ * leading arguments of the given command sequence, with the number of arguments matched.
+ */
+func (a *Argument) walk(args []string, fn func(depth int, arg *Argument)) {
+	fn(0, a)
+	node := a
+	for i, arg := range args {
+		next, ok := node.Args[arg]
+		if !ok {
+			return
+		}
+		fn(i+1, &next)
+		node = &next
 	}
-	if arg, ok := a.Args[args[0]]; ok {
-		// Propagate headers to child elements
-		if arg.Header == "" {
-			arg.Header = a.Header
+}
+
+/* Match returns the header for the given command sequence. The tree is shared by concurrent
+ * connections, so omitted headers are resolved without modifying it.
+ */
+func (a *Argument) Match(args []string) string {
+	// Use default if header is omitted, and propagate headers to child elements
+	header := DefaultHeader
+	a.walk(args, func(_ int, arg *Argument) {
+		if arg.Header != "" {
+			header = arg.Header
 		}
-		if len(args) < 2 {
-			//return arg.Match([]string{})
+	})
+	return header
+}
+
+/* MatchLimit returns the most specific rate limit for the given command sequence, along
+ * with the leading arguments identifying the command it was defined for.
+ */
+func (a *Argument) MatchLimit(args []string) (*RateLimit, []string) {
+	var limit *RateLimit
+	depth := 0
+	a.walk(args, func(d int, arg *Argument) {
+		if arg.Limit != nil {
+			limit, depth = arg.Limit, d
 		}
-		return arg.Match(args[1:])
-	}
-	return a.Header
+	})
+	return limit, args[:depth]
+}
+
+/* MatchIdempotent reports whether the given command sequence may safely be sent more than
+ * once, as marked on the most specific argument which defines it. Commands are not
+ * idempotent unless marked.
+ */
+func (a *Argument) MatchIdempotent(args []string) bool {
+	var idempotent *bool
+	a.walk(args, func(_ int, arg *Argument) {
+		if arg.Idempotent != nil {
+			idempotent = arg.Idempotent
+		}
+	})
+	return idempotent != nil && *idempotent
+}
+
+/* MatchResponse returns the most specific response pattern for the given command sequence,
+ * or an empty string if none is defined.
+ */
+func (a *Argument) MatchResponse(args []string) string {
+	var pattern string
+	a.walk(args, func(_ int, arg *Argument) {
+		if arg.Response != "" {
+			pattern = arg.Response
+		}
+	})
+	return pattern
 }
 
 func (a *Argument) AddArguments(table map[string]string) {
@@ -125,7 +219,7 @@ func (a *Argument) AddArguments(table map[string]string) {
 		if header == "" {
 			header = a.Header
 		}
-		a.Args[arg] = Argument{nil, header}
+		a.Args[arg] = Argument{Header: header}
 	}
 }
 
@@ -0,0 +1,283 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+/* SessionCommand switches a socket connection into session mode, in which several commands
+ * are sent on the same connection. Each command is sent as a line of the usual form
+ * ("[n]:[t] args...") and its response is followed by the SessionEnd line. The session is
+ * acknowledged with a SessionEnd line, and ends when either side closes the connection.
+ */
+const SessionCommand = ControlPrefix + "session"
+
+// Line marking the end of each response in session mode.
+const SessionEnd = StatusPrefix + " end"
+
+// Time after which an idle session is closed by the Handler.
+var SessionIdleTimeout = 5 * time.Minute
+
+var ErrNewline = errors.New("command contains a newline")
+
+// A Session sends several commands to a Wrapper over one socket connection.
+type Session interface {
+	/* Send the given arguments to the socket Wrapper. The Client's parser function is used
+	 * to generate the socketcmd header appropriate for the given arguments.
+	 */
+	Send(args ...string) ([]string, error)
+	/* SendContext sends the given arguments to the socket Wrapper, using the given context
+	 * to manage the exchange. The session is closed if the context is done before the
+	 * response is complete.
+	 */
+	SendContext(ctx context.Context, args ...string) ([]string, error)
+	// Close the session connection.
+	Close() error
+}
+
+/* handleSession runs the commands received on the connection in session mode until the
+ * client closes it or it is idle for the SessionIdleTimeout.
+ */
+func (h *handler) handleSession(conn net.Conn, id uint64, source string, inSession bool) error {
+	if inSession {
+		status := &StatusError{Status: StatusFailed, Message: "already in a session"}
+		_, err := io.WriteString(conn, status.String()+"\n")
+		return err
+	}
+	if _, err := io.WriteString(conn, SessionEnd+"\n"); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, ConnBufferSize), ConnBufferSize)
+	for {
+		if SessionIdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(SessionIdleTimeout))
+		}
+		if !scanner.Scan() {
+			break
+		}
+		conn.SetReadDeadline(time.Time{})
+		if err := h.handleCommand(conn, id, source, scanner.Text(), true); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(conn, SessionEnd+"\n"); err != nil {
+			return err
+		}
+	}
+	// Idle sessions are closed without error
+	var netErr net.Error
+	if err := scanner.Err(); err != nil && !(errors.As(err, &netErr) && netErr.Timeout()) {
+		return err
+	}
+	return nil
+}
+
+func (c *client) Session(ctx context.Context) (Session, error) {
+	s, err := c.openSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &clientSession{c, s}, nil
+}
+
+// clientSession is a Session which is not part of a pool.
+type clientSession struct {
+	c *client
+	s *session
+}
+
+func (cs *clientSession) Send(args ...string) ([]string, error) {
+	return cs.SendContext(context.Background(), args...)
+}
+
+func (cs *clientSession) SendContext(ctx context.Context, args ...string) ([]string, error) {
+	header := cs.c.Parse(args)
+	if header == ForbiddenHeader {
+		return nil, ErrCommandForbidden
+	}
+	return cs.s.exchange(ctx, header, args...)
+}
+
+func (cs *clientSession) Close() error {
+	return cs.s.conn.Close()
+}
+
+// session is an open session connection to the socket.
+type session struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	used   time.Time
+	broken bool
+}
+
+// openSession opens a new connection to the socket and switches it into session mode.
+func (c *client) openSession(ctx context.Context) (*session, error) {
+	conn, err := c.dialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := &session{conn: conn, r: bufio.NewReader(conn)}
+	// The session request is sent as a single command, like any other
+	header := TargetHeader(c.Instance, DefaultHeader)
+	if _, err := s.roundTrip(ctx, header+" "+SessionCommand); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// exchange sends a command in the session and returns its response.
+func (s *session) exchange(ctx context.Context, header string, args ...string) ([]string, error) {
+	msg, err := message(header, args)
+	if err != nil {
+		return nil, err
+	}
+	return s.roundTrip(ctx, msg)
+}
+
+/* roundTrip writes the given message and reads the response lines up to the SessionEnd
+ * line. A status line in the response is returned as an error, as by Client.Send.
+ */
+func (s *session) roundTrip(ctx context.Context, msg string) ([]string, error) {
+	defer s.watch(ctx)()
+	if err := s.write(msg); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	lines, err := s.read()
+	if s.broken {
+		err = ctxErr(ctx, err)
+	}
+	return lines, err
+}
+
+/* watch applies the deadline of the given context to the session connection, and interrupts
+ * any exchange when the context is done. The returned function stops watching the context,
+ * waiting until it can no longer interrupt the connection, and clears the deadline so that
+ * the session may be reused.
+ */
+func (s *session) watch(ctx context.Context) func() {
+	if dl, ok := ctx.Deadline(); ok {
+		s.conn.SetDeadline(dl)
+	} else {
+		s.conn.SetDeadline(time.Time{})
+	}
+	if ctx.Done() == nil {
+		return func() {
+			s.conn.SetDeadline(time.Time{})
+			s.used = time.Now()
+		}
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			s.conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		s.conn.SetDeadline(time.Time{})
+		s.used = time.Now()
+	}
+}
+
+// write sends a message on the session, marking it broken if the write fails.
+func (s *session) write(msg string) error {
+	if _, err := io.WriteString(s.conn, msg); err != nil {
+		s.broken = true
+		return err
+	}
+	return nil
+}
+
+/* read reads the response lines up to the SessionEnd line, returning a status line in the
+ * response as an error, including when the connection is closed after it. The session is
+ * marked broken if the response is not complete.
+ */
+func (s *session) read() ([]string, error) {
+	var results []string
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			s.broken = true
+			// A refused command (e.g. a forbidden session) is closed after its status line
+			if lines, status := splitStatus(results); status != nil {
+				return lines, status
+			}
+			return results, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == SessionEnd {
+			break
+		}
+		results = append(results, line)
+	}
+	return splitStatus(results)
+}
+
+// message formats a command as a line for the session, rejecting embedded newlines.
+func message(header string, args []string) (string, error) {
+	cmd := strings.Join(args, " ")
+	if strings.ContainsAny(cmd, "\r\n") {
+		return "", ErrNewline
+	}
+	return header + " " + cmd + "\n", nil
+}
+
+/* alive reports whether an idle session is still usable. The Handler sends nothing on an
+ * idle session, so any data or error other than a timeout means it is broken.
+ */
+func (s *session) alive() bool {
+	if s.broken || s.r.Buffered() > 0 {
+		return false
+	}
+	// A deadline which has already passed would not read a pending EOF
+	s.conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	_, err := s.r.Peek(1)
+	s.conn.SetReadDeadline(time.Time{})
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+/* ctxErr returns the context error in place of the given error if the context is done. A
+ * timeout is the context's deadline, since the connection deadline may expire just before
+ * the context does.
+ */
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var netErr net.Error
+	if dl, ok := ctx.Deadline(); ok && !time.Now().Before(dl) &&
+		errors.As(err, &netErr) && netErr.Timeout() {
+		return context.DeadlineExceeded
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+package socketcmd_test
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+	"github.com/faceless-saint/go-socketcmd/socketcmdtest"
+
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSession(t *testing.T) {
+	s := socketcmdtest.NewScript().
+		On("ping", "pong").
+		OnMatch(`^echo (.*)$`, 0, "$1")
+	_, c := newWrapper(t, s, allowControl(socketcmd.SessionCommand))
+
+	sess, err := c.Session(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	for _, word := range []string{"one", "two", "three"} {
+		lines, err := sess.Send("echo", word)
+		expect(t, lines, err, word)
+	}
+	lines, err := sess.Send("ping")
+	expect(t, lines, err, "pong")
+
+	want := []string{"echo one", "echo two", "echo three", "ping"}
+	if got := s.Current().Inputs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Inputs() = %q, want %q", got, want)
+	}
+}
+
+func TestSessionDeadline(t *testing.T) {
+	s := socketcmdtest.NewScript().
+		On("ping", "pong").
+		OnDelay("slow", 2*time.Second, "done")
+	_, c := newWrapper(t, s, allowControl(socketcmd.SessionCommand))
+
+	sess, err := c.Session(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+
+	// The deadline of a completed exchange does not apply to the next one
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	lines, err := sess.SendContext(ctx, "ping")
+	cancel()
+	expect(t, lines, err, "pong")
+	time.Sleep(150 * time.Millisecond)
+	lines, err = sess.Send("ping")
+	expect(t, lines, err, "pong")
+
+	// An exchange which outlives its context is interrupted
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := sess.SendContext(ctx, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SendContext(slow) = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("SendContext(slow) returned after %v", elapsed)
+	}
+}
+
+func TestSessionDefaultPolicy(t *testing.T) {
+	_, c := newWrapper(t, socketcmdtest.NewScript())
+	ctx := contextTimeout(t, 5*time.Second)
+
+	// Sessions, subscriptions and attachments must be allowed by a Policy
+	_, err := c.Session(ctx)
+	expectStatus(t, err, socketcmd.StatusForbidden)
+	_, err = c.Subscribe(ctx)
+	expectStatus(t, err, socketcmd.StatusForbidden)
+	_, err = c.Attach(ctx, "")
+	expectStatus(t, err, socketcmd.StatusForbidden)
+	lines, err := c.Send("!status")
+	if err != nil || len(lines) != 1 {
+		t.Fatalf("!status = %q, %v; want the status", lines, err)
+	}
+}
@@ -0,0 +1,141 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+/* A pipeListener is an in-memory net.Listener. Connections are either dialed in-process
+ * or delivered from another listener, such as the listener of a Multiplexer.
+ */
+type pipeListener struct {
+	name  string
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newPipeListener(name string) *pipeListener {
+	return &pipeListener{name: name, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr(l.name)
+}
+
+// DialContext returns a new in-process connection to the listener.
+func (l *pipeListener) DialContext(ctx context.Context) (net.Conn, error) {
+	return l.dialAs(ctx, nil)
+}
+
+/* dialAs returns a new in-process connection to the listener on behalf of the given client
+ * connection, which it is identified and rate limited as. Without a client connection, it is
+ * a localConn.
+ */
+func (l *pipeListener) dialAs(ctx context.Context, peer net.Conn) (net.Conn, error) {
+	client, server := net.Pipe()
+	var conn net.Conn = &localConn{server}
+	if peer != nil {
+		conn = &peerConn{server, peer}
+	}
+	if err := l.deliver(ctx, conn); err != nil {
+		client.Close()
+		server.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// deliver queues the given connection to be accepted by the listener.
+func (l *pipeListener) deliver(ctx context.Context, conn net.Conn) error {
+	select {
+	case l.conns <- conn:
+		return nil
+	case <-l.done:
+		return net.ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// A localConn is the server end of a connection dialed from within this process.
+type localConn struct {
+	net.Conn
+}
+
+func (c *localConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+/* A peerConn is the server end of an in-process connection made on behalf of a client
+ * connection, such as for a broadcast. It is identified as that client.
+ */
+type peerConn struct {
+	net.Conn
+	peer net.Conn
+}
+
+func (c *peerConn) RemoteAddr() net.Addr {
+	return c.peer.RemoteAddr()
+}
+
+func (c *peerConn) NetConn() net.Conn {
+	return c.peer
+}
+
+// A prefixConn replays data already read from the underlying connection.
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *prefixConn) NetConn() net.Conn {
+	return c.Conn
+}
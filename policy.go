@@ -0,0 +1,121 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+/* DefaultControlPolicy allows completions and the read-only control commands which report
+ * the state of the wrapped process. Sessions, subscriptions, the command history, attached
+ * operators and control commands which affect the wrapped process must be explicitly
+ * allowed by a Policy.
+ */
+var DefaultControlPolicy = NewArguments(map[string]string{
+	"!complete": DefaultHeader,
+	"!health":   DefaultHeader,
+	"!pid":      DefaultHeader,
+	"!status":   DefaultHeader,
+	"!uptime":   DefaultHeader,
+}, ForbiddenHeader)
+
+/* A Policy authorizes commands received by a Handler. Commands are matched against the
+ * Commands tree and control commands (including ControlPrefix) against the Control tree,
+ * or DefaultControlPolicy if it is nil. A command is refused if it matches ForbiddenHeader.
+ *
+ * Policies may be stored as JSON files, for example:
+ *
+ *	{
+ *		"commands": {"header": "-1:", "args": {"stop": {"header": "-:"}}},
+ *		"control": {"header": "-:", "args": {"!status": {"header": "-1:"}, "!restart": {"header": "-1:"}}}
+ *	}
+ */
+type Policy struct {
+	Commands Argument  `json:"commands"`
+	Control  *Argument `json:"control,omitempty"`
+}
+
+// LoadPolicy reads a Policy from the given JSON file.
+func LoadPolicy(path string) (*Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p := &Policy{}
+	if err := json.NewDecoder(f).Decode(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Allow reports whether the given command sequence is authorized by the Policy.
+func (p *Policy) Allow(args []string) bool {
+	if len(args) > 0 && strings.HasPrefix(args[0], ControlPrefix) {
+		control := p.Control
+		if control == nil {
+			control = &DefaultControlPolicy
+		}
+		return control.Match(args) != ForbiddenHeader
+	}
+	return p.Commands.Match(args) != ForbiddenHeader
+}
+
+func (h *handler) Policy(p *Policy) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.policy = p
+}
+
+func (h *handler) PolicyFile(path string) error {
+	p, err := LoadPolicy(path)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.policy, h.policyFile = p, path
+	return nil
+}
+
+/* parse returns the header for the given command sequence in the Commands of the Handler's
+ * current Policy, which may be replaced or reloaded at any time.
+ */
+func (h *handler) parse(args []string) string {
+	h.mu.Lock()
+	p := h.policy
+	h.mu.Unlock()
+	if p == nil {
+		p = &Policy{}
+	}
+	return p.Commands.Match(args)
+}
+
+// authorized checks the given command sequence against the Handler's Policy.
+func (h *handler) authorized(args []string) bool {
+	h.mu.Lock()
+	p := h.policy
+	h.mu.Unlock()
+	if p == nil {
+		p = &Policy{}
+	}
+	return p.Allow(args)
+}
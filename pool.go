@@ -0,0 +1,139 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Default number of idle sessions retained by a Client pool.
+const DefaultMaxIdle = 2
+
+/* A Pool configures a Client to send commands over pooled sessions instead of dialing a new
+ * connection for each command.
+ */
+type Pool struct {
+	// Maximum number of idle sessions retained (default DefaultMaxIdle).
+	MaxIdle int
+	/* Maximum number of sessions open at once, or zero for no limit. Commands wait for a
+	 * session to become available until their context is done.
+	 */
+	MaxOpen int
+	// Time after which idle sessions are closed, or zero for no limit.
+	IdleTimeout time.Duration
+}
+
+// pool holds the idle sessions of a Client.
+type pool struct {
+	config Pool
+	sem    chan struct{}
+
+	mu   sync.Mutex
+	idle []*session
+}
+
+func newPool(config Pool) *pool {
+	if config.MaxIdle <= 0 {
+		config.MaxIdle = DefaultMaxIdle
+	}
+	p := &pool{config: config}
+	if config.MaxOpen > 0 {
+		p.sem = make(chan struct{}, config.MaxOpen)
+	}
+	return p
+}
+
+/* get borrows an idle session from the pool, opening a new one if there is none. Idle
+ * sessions are checked before they are reused, and closed if they have expired or broken.
+ */
+func (p *pool) get(ctx context.Context, open func(context.Context) (*session, error)) (
+	*session, error,
+) {
+	if p.sem != nil {
+		select {
+		case p.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	for {
+		p.mu.Lock()
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		// Reuse the most recently used session, which is least likely to have expired
+		s := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if p.expired(s) || !s.alive() {
+			s.conn.Close()
+			continue
+		}
+		return s, nil
+	}
+	s, err := open(ctx)
+	if err != nil {
+		p.release()
+		return nil, err
+	}
+	return s, nil
+}
+
+// put returns a borrowed session to the pool, closing it if it is broken or not needed.
+func (p *pool) put(s *session) {
+	defer p.release()
+	p.mu.Lock()
+	if !s.broken && len(p.idle) < p.config.MaxIdle {
+		p.idle = append(p.idle, s)
+		s = nil
+	}
+	p.mu.Unlock()
+	if s != nil {
+		s.conn.Close()
+	}
+}
+
+func (p *pool) release() {
+	if p.sem != nil {
+		<-p.sem
+	}
+}
+
+func (p *pool) expired(s *session) bool {
+	return p.config.IdleTimeout > 0 && time.Since(s.used) > p.config.IdleTimeout
+}
+
+// close closes the idle sessions in the pool.
+func (p *pool) close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	var err error
+	for _, s := range idle {
+		if err2 := s.conn.Close(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
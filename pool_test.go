@@ -0,0 +1,90 @@
+package socketcmd_test
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+	"github.com/faceless-saint/go-socketcmd/socketcmdtest"
+
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolConcurrent(t *testing.T) {
+	s := socketcmdtest.NewScript().OnMatch(`^echo (.*)$`, 0, "$1")
+	_, c := newWrapper(t, s, allowControl(socketcmd.SessionCommand))
+	c.Pool(socketcmd.Pool{MaxOpen: 2})
+	defer c.Close()
+
+	// Each caller receives the response to its own command
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			word := fmt.Sprint(i)
+			lines, err := c.Send("echo", word)
+			if err == nil && (len(lines) != 1 || lines[0] != word) {
+				err = fmt.Errorf("echo %s: response %q", word, lines)
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestPoolMaxOpen(t *testing.T) {
+	s := socketcmdtest.NewScript().
+		On("ping", "pong").
+		OnDelay("slow", 500*time.Millisecond, "done")
+	_, c := newWrapper(t, s, allowControl(socketcmd.SessionCommand))
+	c.Pool(socketcmd.Pool{MaxOpen: 1})
+	defer c.Close()
+
+	busy := make(chan error, 1)
+	go func() {
+		_, err := c.Send("slow")
+		busy <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// The only session is in use, so the command waits for it until its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.SendContext(ctx, "ping"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SendContext(ping) = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := <-busy; err != nil {
+		t.Fatal(err)
+	}
+	// The session is returned to the pool for the next command
+	lines, err := c.Send("ping")
+	expect(t, lines, err, "pong")
+}
@@ -0,0 +1,34 @@
+//go:build !unix
+
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// Process groups are not supported on this platform.
+func setProcessGroup(_ *exec.Cmd) {}
+
+func signalGroup(_ int, _ os.Signal) error {
+	return errors.New("process groups are not supported on this platform")
+}
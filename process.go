@@ -0,0 +1,161 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+/* A Process is driven by a Wrapper, which forwards socket commands to its stdin and
+ * responds with lines of its stdout. Implementations may launch processes by other means
+ * than os/exec (such as through a container runtime or remote launcher) or fake them
+ * entirely.
+ *
+ * If the Process has a Pid() int method, it is used to report the process ID and to
+ * manage its process group. If the error returned by Wait has an ExitCode() int method
+ * (as *exec.ExitError does), it is used to report the exit code.
+ */
+type Process interface {
+	// Start the process. The I/O streams must be available before Start is called.
+	Start() error
+	// Wait for the process to exit.
+	Wait() error
+	// Signal sends the given signal to the process.
+	Signal(os.Signal) error
+	// Stdin returns the stream written to the process stdin.
+	Stdin() io.Writer
+	// Stdout returns the stream read from the process stdout.
+	Stdout() io.Reader
+	// Stderr returns the stream read from the process stderr, or nil if it is not captured.
+	Stderr() io.Reader
+}
+
+/* A ProcessFunc creates a new, unstarted Process. It is called when a Wrapper is created,
+ * and again each time the Wrapper restarts the process.
+ */
+type ProcessFunc func() (Process, error)
+
+// execProcess is a Process started from an exec.Cmd.
+type execProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+}
+
+/* NewExecProcess returns a new Process for the given command, creating its stdin and stdout
+ * pipes. The stderr of the command is left as configured by cmd.Stderr.
+ */
+func NewExecProcess(cmd *exec.Cmd) (Process, error) {
+	return newExecProcess(cmd)
+}
+
+func newExecProcess(cmd *exec.Cmd) (*execProcess, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	return &execProcess{cmd, stdin, stdout}, nil
+}
+
+func (p *execProcess) Start() error      { return p.cmd.Start() }
+func (p *execProcess) Wait() error       { return p.cmd.Wait() }
+func (p *execProcess) Stdin() io.Writer  { return p.stdin }
+func (p *execProcess) Stdout() io.Reader { return p.stdout }
+func (p *execProcess) Stderr() io.Reader { return nil }
+
+func (p *execProcess) Signal(sig os.Signal) error {
+	if p.cmd.Process == nil {
+		return ErrNotRunning
+	}
+	return p.cmd.Process.Signal(sig)
+}
+
+func (p *execProcess) Pid() int {
+	if p.cmd.Process == nil {
+		return 0
+	}
+	return p.cmd.Process.Pid
+}
+
+// execSpawner returns a function which creates processes from copies of the given command.
+func execSpawner(cmd *exec.Cmd) ProcessFunc {
+	next := cmd
+	return func() (Process, error) {
+		p, err := newExecProcess(next)
+		if err != nil {
+			return nil, err
+		}
+		next = cloneCmd(cmd)
+		return p, nil
+	}
+}
+
+// cloneCmd returns an unstarted copy of the given command.
+func cloneCmd(cmd *exec.Cmd) *exec.Cmd {
+	return &exec.Cmd{
+		Path:        cmd.Path,
+		Args:        cmd.Args,
+		Env:         cmd.Env,
+		Dir:         cmd.Dir,
+		Stderr:      cmd.Stderr,
+		ExtraFiles:  cmd.ExtraFiles,
+		SysProcAttr: cmd.SysProcAttr,
+	}
+}
+
+// processPid returns the process ID of the given Process, or zero if it is not known.
+func processPid(p Process) int {
+	if pp, ok := p.(interface{ Pid() int }); ok {
+		return pp.Pid()
+	}
+	return 0
+}
+
+// exitCode returns the exit code for the given Wait result, or -1 if it is not known.
+func exitCode(err error) int {
+	var exitErr interface{ ExitCode() int }
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// exitDescription describes the exit of a process with the given Wait result.
+func exitDescription(err error) string {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() < 0 {
+		return "process exited with signal " + strings.TrimPrefix(exitErr.String(), "signal: ")
+	}
+	if code := exitCode(err); code >= 0 {
+		return fmt.Sprintf("process exited with code %d", code)
+	}
+	return "process exited: " + err.Error()
+}
@@ -0,0 +1,185 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+/* A RateLimit configures a token bucket. Each command consumes one token, and tokens are
+ * replenished at Rate tokens per second up to a maximum of Burst tokens.
+ */
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst,omitempty"`
+}
+
+/* A RateLimiter decides whether a client identity may send the given command sequence.
+ * If the command is refused, the suggested delay before retrying is returned.
+ */
+type RateLimiter interface {
+	Allow(identity string, args []string) (bool, time.Duration)
+}
+
+/* NewRateLimiter returns a RateLimiter which applies the global limit to every command
+ * sent by an identity, along with any per-command limits defined in the given policy.
+ * A zero RateLimit or nil policy disables the corresponding check.
+ */
+func NewRateLimiter(global RateLimit, policy *Argument) RateLimiter {
+	return &rateLimiter{global: global, policy: policy, buckets: make(map[string]*bucket)}
+}
+
+type rateLimiter struct {
+	global RateLimit
+	policy *Argument
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func (l *rateLimiter) Allow(identity string, args []string) (bool, time.Duration) {
+	refused, wait := l.allow(identity, [][]string{args})
+	return refused < 0, wait
+}
+
+/* allow consumes a token for each of the given commands from every bucket that applies to
+ * it, but only if tokens are available for all of them. Otherwise it returns the index of
+ * the first command which would be refused, and the time until enough tokens are available.
+ */
+func (l *rateLimiter) allow(identity string, cmds [][]string) (int, time.Duration) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	// Count the tokens needed from each bucket that applies to the commands
+	need := make(map[*bucket]float64)
+	refused := -1
+	for i, args := range cmds {
+		for _, b := range l.applicable(identity, args, now) {
+			if need[b]++; refused < 0 && need[b] > b.tokens {
+				refused = i
+			}
+		}
+	}
+
+	// Only consume tokens if every bucket has enough available
+	if refused >= 0 {
+		var wait time.Duration
+		for b, n := range need {
+			if d := b.wait(n); d > wait {
+				wait = d
+			}
+		}
+		return refused, wait
+	}
+	for b, n := range need {
+		b.tokens -= n
+	}
+	return -1, 0
+}
+
+// applicable returns the refilled buckets that apply to the given command.
+func (l *rateLimiter) applicable(identity string, args []string, now time.Time) []*bucket {
+	var buckets []*bucket
+	if l.global.Rate > 0 {
+		buckets = append(buckets, l.bucket(identity, l.global, now))
+	}
+	if l.policy != nil {
+		if limit, path := l.policy.MatchLimit(args); limit != nil && limit.Rate > 0 {
+			key := identity + "\x00" + strings.Join(path, " ")
+			buckets = append(buckets, l.bucket(key, *limit, now))
+		}
+	}
+	return buckets
+}
+
+/* allowAll checks the given commands against the RateLimiter together, returning the index
+ * of the first command which is refused (or -1) and the suggested delay. A RateLimiter
+ * returned by NewRateLimiter only consumes tokens if every command is allowed; any other
+ * RateLimiter checks the commands in order.
+ */
+func allowAll(limiter RateLimiter, identity string, cmds [][]string) (int, time.Duration) {
+	if l, ok := limiter.(*rateLimiter); ok {
+		return l.allow(identity, cmds)
+	}
+	for i, args := range cmds {
+		if ok, wait := limiter.Allow(identity, args); !ok {
+			return i, wait
+		}
+	}
+	return -1, 0
+}
+
+// bucket returns the refilled bucket for the given key, creating it if necessary.
+func (l *rateLimiter) bucket(key string, limit RateLimit, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limit: limit, tokens: float64(limit.burst()), last: now}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+	return b
+}
+
+// sweep discards buckets that have refilled completely, at most once per minute.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if b.refill(now); b.tokens >= float64(b.limit.burst()) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	if max := float64(b.limit.burst()); b.tokens > max {
+		b.tokens = max
+	}
+	b.last = now
+}
+
+// wait returns the time until n tokens are available, or zero if they are available now.
+func (b *bucket) wait(n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// burst returns the bucket capacity, which is always at least one token.
+func (r RateLimit) burst() int {
+	if r.Burst < 1 {
+		return 1
+	}
+	return r.Burst
+}
@@ -0,0 +1,111 @@
+package socketcmd_test
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+	"github.com/faceless-saint/go-socketcmd/socketcmdtest"
+
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func limit(rate float64, burst int) func(socketcmd.Wrapper) {
+	return func(w socketcmd.Wrapper) {
+		w.Limit(socketcmd.NewRateLimiter(socketcmd.RateLimit{Rate: rate, Burst: burst}, nil))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s := socketcmdtest.NewScript().On("ping", "pong")
+	_, c := newWrapper(t, s, limit(0.1, 2))
+
+	for i := 0; i < 2; i++ {
+		lines, err := c.Send("ping")
+		expect(t, lines, err, "pong")
+	}
+	_, err := c.Send("ping")
+	if serr := expectStatus(t, err, socketcmd.StatusRateLimited); serr.RetryAfter <= 0 {
+		t.Errorf("RetryAfter = %v, want a delay", serr.RetryAfter)
+	}
+	// Refused commands are not sent to the process
+	if got := len(s.Current().Inputs()); got != 2 {
+		t.Errorf("process read %d inputs, want 2", got)
+	}
+}
+
+func TestRateLimitAPI(t *testing.T) {
+	s := socketcmdtest.NewScript().On("ping", "pong")
+	w, c := newWrapper(t, s, limit(0.1, 2))
+	srv := httptest.NewServer(w.ExposeAPI(func([]string) string { return "1:" }))
+	defer srv.Close()
+
+	post := func() int {
+		resp, err := http.Post(srv.URL+"/", "application/json",
+			strings.NewReader(`{"command": ["ping"]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for i := 0; i < 2; i++ {
+		if code := post(); code != http.StatusOK {
+			t.Fatalf("POST %d: status %d, want %d", i, code, http.StatusOK)
+		}
+	}
+	if code := post(); code != http.StatusTooManyRequests {
+		t.Errorf("POST: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	// API requests are limited by HTTP client, and not charged to the socket clients
+	for i := 0; i < 2; i++ {
+		lines, err := c.Send("ping")
+		expect(t, lines, err, "pong")
+	}
+}
+
+func TestRateLimitAPIBatch(t *testing.T) {
+	s := socketcmdtest.NewScript().On("ping", "pong")
+	w, _ := newWrapper(t, s, limit(0.1, 2))
+	srv := httptest.NewServer(w.ExposeAPI(func([]string) string { return "1:" }))
+	defer srv.Close()
+
+	post := func(cmds ...string) int {
+		resp, err := http.Post(srv.URL+"/", "text/plain", strings.NewReader(strings.Join(cmds, "\n")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// A batch which exceeds the limit is refused without consuming the tokens of its commands
+	if code := post("ping", "ping", "ping"); code != http.StatusTooManyRequests {
+		t.Fatalf("POST 3 commands: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := post("ping", "ping"); code != http.StatusOK {
+		t.Fatalf("POST 2 commands: status %d, want %d", code, http.StatusOK)
+	}
+	if got := s.Current().Inputs(); len(got) != 2 {
+		t.Errorf("Inputs() = %q, want only the allowed batch", got)
+	}
+}
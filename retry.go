@@ -0,0 +1,200 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults for a client Retry policy.
+const (
+	DefaultRetryAttempts = 3
+	DefaultRetryBackoff  = 100 * time.Millisecond
+	DefaultMaxBackoff    = 10 * time.Second
+)
+
+/* DefaultRetryStatuses are the statuses retried by default: those given while the process
+ * is starting or restarting, or when the client is rate limited.
+ */
+var DefaultRetryStatuses = []string{StatusRateLimited, StatusStarting, StatusExited}
+
+/* Statuses which are only given before a command is forwarded, so retrying them never sends
+ * a command more than once.
+ */
+var unsentStatuses = map[string]bool{StatusRateLimited: true, StatusStarting: true}
+
+/* A Retry configures how a Client retries failed commands, with exponential backoff between
+ * attempts. Commands which failed before they were sent (such as when the socket cannot be
+ * dialed, or the Wrapper is starting or rate limiting the client) are always retried.
+ * Commands which may have reached the process (such as when it exits during the response,
+ * or the connection fails) are only retried if the Client's policy marks them idempotent.
+ */
+type Retry struct {
+	// Maximum number of attempts, including the first (default DefaultRetryAttempts).
+	Attempts int
+	// Delay before the first retry, doubled for each later retry (default DefaultRetryBackoff).
+	Backoff time.Duration
+	// Maximum delay between attempts (default DefaultMaxBackoff).
+	MaxBackoff time.Duration
+	/* Fraction of each delay which is randomized, between 0 and 1, so that clients do not
+	 * retry in step. A delay suggested by the Wrapper is used if it is longer.
+	 */
+	Jitter float64
+	// Statuses which are retried (default DefaultRetryStatuses).
+	Statuses []string
+}
+
+// An Endpoint is the address of a socket Wrapper.
+type Endpoint struct {
+	Protocol string
+	Address  string
+}
+
+// Balance determines the order in which a Client tries its Endpoints.
+type Balance int
+
+const (
+	// Try the endpoints in order, starting with the first for each command
+	BalanceFailover Balance = iota
+	// Start with the next endpoint for each command, trying the others in order after it
+	BalanceRoundRobin
+)
+
+func (c *client) Retry(r Retry) {
+	if r.Attempts <= 0 {
+		r.Attempts = DefaultRetryAttempts
+	}
+	if r.Backoff <= 0 {
+		r.Backoff = DefaultRetryBackoff
+	}
+	if r.MaxBackoff <= 0 {
+		r.MaxBackoff = DefaultMaxBackoff
+	}
+	if r.Statuses == nil {
+		r.Statuses = DefaultRetryStatuses
+	}
+	c.retry = &r
+}
+
+func (c *client) Endpoints(balance Balance, endpoints ...Endpoint) {
+	c.balance, c.endpoints = balance, endpoints
+}
+
+func (c *client) Policy(policy *Argument) {
+	if policy == nil {
+		c.Parse, c.policy = DefaultParseFunc, nil
+		return
+	}
+	c.Parse, c.policy = policy.ParseFunc(), policy
+}
+
+/* dialEndpoints dials the Client's endpoints in turn, returning the first connection made
+ * or the last error.
+ */
+func (c *client) dialEndpoints(ctx context.Context) (net.Conn, error) {
+	start := 0
+	if c.balance == BalanceRoundRobin {
+		start = int((atomic.AddUint32(&c.next, 1) - 1) % uint32(len(c.endpoints)))
+	}
+	var err error
+	for i := range c.endpoints {
+		e := c.endpoints[(start+i)%len(c.endpoints)]
+		var conn net.Conn
+		if conn, err = c.d.DialContext(ctx, e.Protocol, e.Address); err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+/* withRetry calls the given attempt function until it succeeds or the error is not to be
+ * retried. The attempt function reports whether the command may have been sent.
+ */
+func (c *client) withRetry(ctx context.Context, args []string,
+	attempt func(context.Context) (lines []string, sent bool, err error),
+) ([]string, error) {
+	if c.retry == nil {
+		lines, _, err := attempt(ctx)
+		return lines, err
+	}
+	idempotent := c.policy != nil && c.policy.MatchIdempotent(args)
+	delay := c.retry.Backoff
+	for n := 1; ; n++ {
+		lines, sent, err := attempt(ctx)
+		if err == nil || n >= c.retry.Attempts || !c.retry.retryable(err, sent, idempotent) {
+			return lines, err
+		}
+
+		// Wait for the backoff delay, or longer if the Wrapper suggests it
+		wait := c.retry.jitter(delay)
+		var status *StatusError
+		if errors.As(err, &status) && status.RetryAfter > wait {
+			wait = status.RetryAfter
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return lines, err
+		}
+		if delay *= 2; delay > c.retry.MaxBackoff {
+			delay = c.retry.MaxBackoff
+		}
+	}
+}
+
+// retryable reports whether the given error may be retried.
+func (r *Retry) retryable(err error, sent, idempotent bool) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ErrCommandForbidden) || errors.Is(err, ErrNewline) {
+		return false
+	}
+	var status *StatusError
+	if !errors.As(err, &status) {
+		// Transport errors
+		return !sent || idempotent
+	}
+	for _, s := range r.Statuses {
+		if s == status.Status {
+			return unsentStatuses[s] || idempotent
+		}
+	}
+	return false
+}
+
+// jitter randomizes the given delay by the configured fraction.
+func (r *Retry) jitter(d time.Duration) time.Duration {
+	if r.Jitter <= 0 {
+		return d
+	}
+	j := r.Jitter
+	if j > 1 {
+		j = 1
+	}
+	return time.Duration(float64(d) * (1 + j*(2*rand.Float64()-1)))
+}
@@ -0,0 +1,177 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default timeouts of the HTTP servers started by the WrapperAPI and MultiplexerAPI.
+var (
+	APIReadHeaderTimeout = 10 * time.Second
+	APIReadTimeout       = 30 * time.Second
+	APIWriteTimeout      = 2 * time.Minute
+	APIIdleTimeout       = 2 * time.Minute
+)
+
+// A Middleware wraps the http.Handler of an API, such as to authenticate or log requests.
+type Middleware func(http.Handler) http.Handler
+
+/* apiServer routes the requests of an API through its own ServeMux and middleware, and
+ * manages the HTTP servers started for it.
+ */
+type apiServer struct {
+	mux *http.ServeMux
+
+	mu         sync.Mutex
+	handler    http.Handler
+	middleware []Middleware
+	template   *http.Server
+	servers    []*http.Server
+	paths      map[string]bool
+	// Closed by Shutdown to end long-lived responses, such as event streams
+	stop chan struct{}
+}
+
+func newAPIServer() *apiServer {
+	mux := http.NewServeMux()
+	return &apiServer{
+		mux:     mux,
+		handler: mux,
+		paths:   make(map[string]bool),
+		stop:    make(chan struct{}),
+	}
+}
+
+// handle registers the handler function for the given path, unless it is already registered.
+func (s *apiServer) handle(path string, fn http.HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.paths[path] {
+		s.paths[path] = true
+		s.mux.HandleFunc(path, fn)
+	}
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	h := s.handler
+	s.mu.Unlock()
+	h.ServeHTTP(w, r)
+}
+
+func (s *apiServer) Use(middleware ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.middleware = append(s.middleware, middleware...)
+	// The first middleware is the outermost
+	var h http.Handler = s.mux
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		h = s.middleware[i](h)
+	}
+	s.handler = h
+}
+
+func (s *apiServer) Server(template *http.Server) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.template = template
+}
+
+func (s *apiServer) Serve(l net.Listener) error {
+	return s.serve(l, "", "")
+}
+
+func (s *apiServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	servers := s.servers
+	s.servers = nil
+	close(s.stop)
+	s.stop = make(chan struct{})
+	s.mu.Unlock()
+	var err error
+	for _, srv := range servers {
+		if err2 := srv.Shutdown(ctx); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// stopping returns a channel which is closed when the API is shut down.
+func (s *apiServer) stopping() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stop
+}
+
+// listenAndServe listens on the given address, serving TLS if a certificate is given.
+func (s *apiServer) listenAndServe(addr, certFile, keyFile string) error {
+	l, err := listenAddr(addr)
+	if err != nil {
+		return err
+	}
+	return s.serve(l, certFile, keyFile)
+}
+
+func (s *apiServer) serve(l net.Listener, certFile, keyFile string) error {
+	srv := s.newServer()
+	s.mu.Lock()
+	s.servers = append(s.servers, srv)
+	s.mu.Unlock()
+	if certFile != "" || keyFile != "" {
+		return srv.ServeTLS(l, certFile, keyFile)
+	}
+	return srv.Serve(l)
+}
+
+// newServer returns a new HTTP server for the API, configured by the server template.
+func (s *apiServer) newServer() *http.Server {
+	s.mu.Lock()
+	t := s.template
+	s.mu.Unlock()
+	srv := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: APIReadHeaderTimeout,
+		ReadTimeout:       APIReadTimeout,
+		WriteTimeout:      APIWriteTimeout,
+		IdleTimeout:       APIIdleTimeout,
+	}
+	if t != nil {
+		srv.ReadHeaderTimeout, srv.ReadTimeout = t.ReadHeaderTimeout, t.ReadTimeout
+		srv.WriteTimeout, srv.IdleTimeout = t.WriteTimeout, t.IdleTimeout
+		srv.MaxHeaderBytes, srv.TLSConfig, srv.ErrorLog = t.MaxHeaderBytes, t.TLSConfig, t.ErrorLog
+	}
+	return srv
+}
+
+/* listenAddr opens a listener on the given address, which is either "unix:" followed by
+ * the path of a Unix domain socket, or a TCP address.
+ */
+func listenAddr(addr string) (net.Listener, error) {
+	if path := strings.TrimPrefix(addr, "unix:"); path != addr {
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
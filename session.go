@@ -0,0 +1,203 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Kind of an event in a session transcript.
+type EventKind string
+
+const (
+	EventConnect    EventKind = "connect"
+	EventDisconnect EventKind = "disconnect"
+	EventCommand    EventKind = "command"
+	EventControl    EventKind = "control"
+	EventStdin      EventKind = "stdin"
+	EventStdout     EventKind = "stdout"
+	EventExit       EventKind = "exit"
+)
+
+/* An Event is an entry in a session transcript. Events from the same socket connection
+ * share a connection number, which is zero for events not related to a connection.
+ */
+type Event struct {
+	Time   time.Time `json:"time"`
+	Kind   EventKind `json:"kind"`
+	Conn   uint64    `json:"conn,omitempty"`
+	Source string    `json:"source,omitempty"`
+	Header string    `json:"header,omitempty"`
+	Line   string    `json:"line,omitempty"`
+}
+
+// String formats the Event as it is shown in a transcript playback.
+func (e Event) String() string {
+	switch e.Kind {
+	case EventConnect, EventDisconnect:
+		return fmt.Sprintf("--- %s #%d (%s)", e.Kind, e.Conn, e.Source)
+	case EventCommand, EventControl:
+		return fmt.Sprintf("(%s #%d)-> %s", e.Source, e.Conn, e.Line)
+	case EventStdin:
+		// Input from attached operators is attributed to them
+		if e.Conn != 0 {
+			return "[" + e.Source + "] " + e.Line
+		}
+		return "(stdin)-> " + e.Line
+	case EventAttach, EventDetach:
+		return fmt.Sprintf("--- %s %sed", e.Source, e.Kind)
+	case EventLock:
+		return "--- " + e.Source + " took the write lock"
+	case EventUnlock:
+		return "--- " + e.Source + " released the write lock"
+	case EventExit:
+		return "--- " + e.Line
+	}
+	return e.Line
+}
+
+/* A recorder writes session events to a transcript as JSON lines.
+ */
+type recorder struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+	closed bool
+}
+
+func (r *recorder) write(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	if err := r.enc.Encode(e); err != nil {
+		// Log the first failure only, rather than once per line of output
+		log.Println("session recorder:", err)
+		r.closed = true
+	}
+}
+
+/* Record writes a transcript of the session to the given Writer, replacing any previous
+ * recorder. Recording stops if the Writer is nil. The previous Writer is not written to
+ * after Record returns.
+ */
+func (h *handler) Record(w io.Writer) {
+	var rec *recorder
+	if w != nil {
+		rec = &recorder{enc: json.NewEncoder(w)}
+	}
+	h.setRecorder(rec)
+}
+
+/* RecordFile appends a transcript of the session to the named file, which is closed when
+ * the recorder is replaced.
+ */
+func (h *handler) RecordFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	h.setRecorder(&recorder{enc: json.NewEncoder(f), closer: f})
+	return nil
+}
+
+func (h *handler) setRecorder(rec *recorder) {
+	h.mu.Lock()
+	prev := h.rec
+	h.rec = rec
+	h.mu.Unlock()
+	if prev == nil {
+		return
+	}
+	// Wait for any event being written before closing the previous recorder
+	prev.mu.Lock()
+	defer prev.mu.Unlock()
+	prev.closed = true
+	if prev.closer != nil {
+		if err := prev.closer.Close(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// event adds an event to the session transcript, if it is being recorded.
+func (h *handler) event(kind EventKind, conn uint64, source, header, line string) {
+	e := Event{time.Now(), kind, conn, source, header, line}
+	h.publish(e)
+	h.mu.Lock()
+	rec := h.rec
+	h.mu.Unlock()
+	if rec == nil {
+		return
+	}
+	rec.write(e)
+}
+
+// ReadTranscript reads the events of a session transcript.
+func ReadTranscript(r io.Reader) ([]Event, error) {
+	var events []Event
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for dec.More() {
+		var e Event
+		if err := dec.Decode(&e); err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// LoadTranscript reads the events of the session transcript in the named file.
+func LoadTranscript(path string) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadTranscript(f)
+}
+
+/* Playback writes the given events to the Writer with the timing of the original session,
+ * scaled by the given speed (e.g. 2 plays back twice as fast). Events are written without
+ * delay if the speed is not positive.
+ */
+func Playback(w io.Writer, events []Event, speed float64) error {
+	if len(events) == 0 {
+		return nil
+	}
+	start := events[0].Time
+	for i, e := range events {
+		if speed > 0 && i > 0 {
+			time.Sleep(time.Duration(float64(e.Time.Sub(events[i-1].Time)) / speed))
+		}
+		offset := e.Time.Sub(start).Truncate(time.Millisecond)
+		if _, err := fmt.Fprintf(w, "%10s %s\n", "+"+offset.String(), e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
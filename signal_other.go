@@ -0,0 +1,58 @@
+//go:build !unix
+
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Signal sent to the wrapped process to request that it stop
+var terminateSignal = os.Kill
+
+// Signals relayed to the wrapped process by ForwardSignals if none are given
+var DefaultForwardSignals = []os.Signal{os.Interrupt}
+
+var signals = map[string]os.Signal{
+	"INT":  os.Interrupt,
+	"KILL": os.Kill,
+}
+
+/* ParseSignal returns the signal with the given name (with or without the "SIG" prefix).
+ * Only SIGINT and SIGKILL are supported on this platform.
+ */
+func ParseSignal(name string) (os.Signal, error) {
+	if sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]; ok {
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unknown signal: %s", name)
+}
+
+// signalName returns the canonical name of the signal, without the "SIG" prefix.
+func signalName(sig os.Signal) string {
+	for name, s := range signals {
+		if s == sig {
+			return name
+		}
+	}
+	return sig.String()
+}
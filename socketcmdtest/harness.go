@@ -0,0 +1,105 @@
+package socketcmdtest
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/* Listen returns a listener on a Unix socket in a new temporary directory, which are both
+ * removed when the test finishes.
+ */
+func Listen(t testing.TB) net.Listener {
+	t.Helper()
+	// Socket paths are limited to around 100 bytes, which t.TempDir may exceed
+	dir, err := os.MkdirTemp("", "socketcmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	ln, err := net.Listen("unix", filepath.Join(dir, "test.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+/* NewClient returns a Client for the socket listener, using the default parser function.
+ */
+func NewClient(ln net.Listener) socketcmd.Client {
+	return socketcmd.NewClient(ln.Addr().Network(), ln.Addr().String(), nil)
+}
+
+/* NewHandler starts a Handler for the given Process on a temporary Unix socket and returns
+ * it with a Client connected to it. The Process is started, and is stopped when the test
+ * finishes. The optional setup functions are applied to the Handler before it is started.
+ */
+func NewHandler(t testing.TB, p *Process, setup ...func(socketcmd.Handler)) (
+	socketcmd.Handler, socketcmd.Client,
+) {
+	t.Helper()
+	ln := Listen(t)
+	h := socketcmd.NewHandler(ln, p.Stdin(), p.Stdout())
+	for _, fn := range setup {
+		fn(h)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	h.Start()
+	t.Cleanup(func() { p.Exit() })
+	return h, NewClient(ln)
+}
+
+/* NewWrapper starts a Wrapper around Processes following the given Script on a temporary
+ * Unix socket and returns it with a Client connected to it. The Wrapper is stopped when the
+ * test finishes. The optional setup functions are applied to the Wrapper before it is
+ * started.
+ */
+func NewWrapper(t testing.TB, s *Script, setup ...func(socketcmd.Wrapper)) (
+	socketcmd.Wrapper, socketcmd.Client,
+) {
+	t.Helper()
+	ln := Listen(t)
+	w, err := socketcmd.NewWithProcess(ln, s.ProcessFunc())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range setup {
+		fn(w)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := w.Stop(); err != nil && !errors.Is(err, socketcmd.ErrNotRunning) {
+			t.Error(err)
+		}
+		w.Wait()
+	})
+	return w, NewClient(ln)
+}
@@ -0,0 +1,183 @@
+package socketcmdtest
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// oneLine sets the Client to expect a response of one line to every command.
+func oneLine(c socketcmd.Client) socketcmd.Client {
+	c.Policy(&socketcmd.Argument{Header: "1:"})
+	return c
+}
+
+// waitDone waits for the channel to be closed, failing the test if it takes too long.
+func waitDone(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the process to exit")
+	}
+}
+
+func TestProcessRespond(t *testing.T) {
+	p := NewScript().On("ping", "pong").Process()
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Exit()
+
+	go io.WriteString(p.Stdin(), "ping\n")
+	line, err := bufio.NewReader(p.Stdout()).ReadString('\n')
+	if err != nil || line != "pong\n" {
+		t.Fatalf("read %q, %v, want pong", line, err)
+	}
+	if got, want := p.Inputs(), []string{"ping"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Inputs() = %q, want %q", got, want)
+	}
+}
+
+func TestProcessExit(t *testing.T) {
+	p := NewScript().Process()
+	if err := p.Wait(); err != ErrNotStarted {
+		t.Errorf("Wait() before Start = %v, want %v", err, ErrNotStarted)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(); err != ErrAlreadyStarted {
+		t.Errorf("second Start() = %v, want %v", err, ErrAlreadyStarted)
+	}
+	if err := p.Crash(3); err != nil {
+		t.Fatal(err)
+	}
+	var exit *ExitError
+	if err := p.Wait(); !errors.As(err, &exit) || exit.ExitCode() != 3 {
+		t.Errorf("Wait() = %v, want exit status 3", err)
+	}
+	if err := p.Exit(); err != ErrExited {
+		t.Errorf("Exit() after exiting = %v, want %v", err, ErrExited)
+	}
+	if err := p.Emit("late"); err != ErrExited {
+		t.Errorf("Emit() after exiting = %v, want %v", err, ErrExited)
+	}
+
+	p = NewScript().Process()
+	p.Start()
+	p.Exit()
+	if err := p.Wait(); err != nil {
+		t.Errorf("Wait() after Exit = %v, want nil", err)
+	}
+}
+
+func TestProcessCrashOn(t *testing.T) {
+	p := NewScript().CrashOn("stop", 2).Process()
+	p.Start()
+	io.WriteString(p.Stdin(), "stop\n")
+	waitDone(t, p.Done())
+	var exit *ExitError
+	if err := p.Wait(); !errors.As(err, &exit) || exit.Code != 2 {
+		t.Errorf("Wait() = %v, want exit status 2", err)
+	}
+}
+
+func TestProcessSignal(t *testing.T) {
+	p := NewScript().Process()
+	if err := p.Signal(os.Interrupt); err != ErrNotStarted {
+		t.Errorf("Signal() before Start = %v, want %v", err, ErrNotStarted)
+	}
+	p.Start()
+	if err := p.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+	var exit *ExitError
+	if err := p.Wait(); !errors.As(err, &exit) || exit.Signal != os.Interrupt || exit.ExitCode() != -1 {
+		t.Errorf("Wait() = %v, want %v", err, &ExitError{Code: -1, Signal: os.Interrupt})
+	}
+	if err := p.Signal(os.Kill); err != ErrExited {
+		t.Errorf("Signal() after exiting = %v, want %v", err, ErrExited)
+	}
+	if got, want := p.Signals(), []os.Signal{os.Interrupt}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Signals() = %v, want %v", got, want)
+	}
+}
+
+func TestNewHandler(t *testing.T) {
+	p := NewScript().On("ping", "pong").Process()
+	_, c := NewHandler(t, p)
+	if lines, err := oneLine(c).Send("ping"); err != nil || !reflect.DeepEqual(lines, []string{"pong"}) {
+		t.Errorf("Send(ping) = %q, %v, want pong", lines, err)
+	}
+}
+
+func TestNewWrapper(t *testing.T) {
+	s := NewScript().On("ping", "pong").CrashOn("crash", 3)
+	w, c := NewWrapper(t, s)
+	oneLine(c)
+	if lines, err := c.Send("ping"); err != nil || !reflect.DeepEqual(lines, []string{"pong"}) {
+		t.Errorf("Send(ping) = %q, %v, want pong", lines, err)
+	}
+
+	// The Script also applies to the Process which replaces it on restart
+	first := s.Current()
+	if err := w.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Current() == first {
+		t.Fatal("Restart() did not create a new Process")
+	}
+	if lines, err := c.Send("ping"); err != nil || !reflect.DeepEqual(lines, []string{"pong"}) {
+		t.Errorf("Send(ping) after restart = %q, %v, want pong", lines, err)
+	}
+	if got, want := s.Current().Inputs(), []string{"ping"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Inputs() after restart = %q, want %q", got, want)
+	}
+
+	c.Send("crash")
+	waitDone(t, w.Done())
+	if code := w.ExitCode(); code != 3 {
+		t.Errorf("ExitCode() = %d, want 3", code)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	start := time.Now()
+	events := []socketcmd.Event{
+		{Time: start, Kind: socketcmd.EventCommand, Header: "2:", Line: "list"},
+		{Time: start.Add(10 * time.Millisecond), Kind: socketcmd.EventStdout, Line: "alice"},
+		{Time: start.Add(20 * time.Millisecond), Kind: socketcmd.EventStdout, Line: "bob"},
+		{Time: start.Add(30 * time.Millisecond), Kind: socketcmd.EventCommand, Header: "1:", Line: "say hi"},
+		{Time: start.Add(40 * time.Millisecond), Kind: socketcmd.EventStdout, Line: "[Server] hi"},
+	}
+	replayed := Replay(t, events)
+	kinds := []socketcmd.EventKind{socketcmd.EventCommand, socketcmd.EventStdout}
+	if got, want := Lines(replayed, kinds...), Lines(events, kinds...); !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+}
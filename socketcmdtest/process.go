@@ -0,0 +1,265 @@
+/* Package socketcmdtest provides a scriptable fake process and helpers for writing hermetic
+ * tests of socketcmd Wrappers and Clients, without running a real interactive program.
+ */
+package socketcmdtest
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrAlreadyStarted = errors.New("socketcmdtest: process already started")
+	ErrNotStarted     = errors.New("socketcmdtest: process not started")
+	ErrExited         = errors.New("socketcmdtest: process exited")
+)
+
+/* An ExitError is returned by Process.Wait when the fake process exits with a non-zero code
+ * or because of a signal.
+ */
+type ExitError struct {
+	Code   int
+	Signal os.Signal
+}
+
+func (e *ExitError) Error() string {
+	if e.Signal != nil {
+		return "signal: " + e.Signal.String()
+	}
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// ExitCode returns the exit code of the process, or -1 if it was stopped by a signal.
+func (e *ExitError) ExitCode() int {
+	if e.Signal != nil {
+		return -1
+	}
+	return e.Code
+}
+
+/* A Process is a fake socketcmd.Process following a Script. It reads lines of input from
+ * its stdin, writing the scripted responses to its stdout, and exits when it is signalled,
+ * when it reads an input the Script crashes on, or when Crash or Exit is called.
+ */
+type Process struct {
+	script *Script
+
+	stdinR  *io.PipeReader
+	stdinW  *io.PipeWriter
+	stdoutR *io.PipeReader
+	stdoutW *io.PipeWriter
+
+	mu      sync.Mutex
+	wmu     sync.Mutex
+	started bool
+	inputs  []string
+	signals []os.Signal
+	err     error
+	done    chan struct{}
+}
+
+func newProcess(s *Script) *Process {
+	p := &Process{script: s, done: make(chan struct{})}
+	p.stdinR, p.stdinW = io.Pipe()
+	p.stdoutR, p.stdoutW = io.Pipe()
+	return p
+}
+
+func (p *Process) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.started {
+		return ErrAlreadyStarted
+	}
+	p.started = true
+	go p.run()
+
+	p.script.mu.Lock()
+	emits := append([]Response(nil), p.script.emits...)
+	p.script.mu.Unlock()
+	for _, resp := range emits {
+		go p.respond(resp.Delay, resp.Lines)
+	}
+	return nil
+}
+
+func (p *Process) Wait() error {
+	p.mu.Lock()
+	started := p.started
+	p.mu.Unlock()
+	if !started {
+		return ErrNotStarted
+	}
+	<-p.done
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+/* Signal records the given signal and stops the process, as a process without signal
+ * handlers would.
+ */
+func (p *Process) Signal(sig os.Signal) error {
+	p.mu.Lock()
+	if !p.started {
+		p.mu.Unlock()
+		return ErrNotStarted
+	}
+	select {
+	case <-p.done:
+		// Signals cannot be delivered to a process which has exited
+		p.mu.Unlock()
+		return ErrExited
+	default:
+	}
+	p.signals = append(p.signals, sig)
+	p.mu.Unlock()
+	if !p.exit(&ExitError{Code: -1, Signal: sig}) {
+		return ErrExited
+	}
+	return nil
+}
+
+func (p *Process) Stdin() io.Writer  { return p.stdinW }
+func (p *Process) Stdout() io.Reader { return p.stdoutR }
+func (p *Process) Stderr() io.Reader { return nil }
+
+/* Emit writes the given unsolicited lines of output immediately. It returns ErrExited if the
+ * process has exited.
+ */
+func (p *Process) Emit(lines ...string) error {
+	select {
+	case <-p.done:
+		return ErrExited
+	default:
+	}
+	return p.write(lines)
+}
+
+// Crash makes the process exit immediately with the given code.
+func (p *Process) Crash(code int) error {
+	var err error
+	if code != 0 {
+		err = &ExitError{Code: code}
+	}
+	if !p.exit(err) {
+		return ErrExited
+	}
+	return nil
+}
+
+// Exit makes the process exit successfully.
+func (p *Process) Exit() error {
+	return p.Crash(0)
+}
+
+// Inputs returns the lines of input read by the process.
+func (p *Process) Inputs() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.inputs...)
+}
+
+// Signals returns the signals sent to the process.
+func (p *Process) Signals() []os.Signal {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]os.Signal(nil), p.signals...)
+}
+
+// Done returns a channel which is closed when the process exits.
+func (p *Process) Done() <-chan struct{} {
+	return p.done
+}
+
+// run reads lines of input and reacts to them as described by the Script.
+func (p *Process) run() {
+	scanner := bufio.NewScanner(p.stdinR)
+	for scanner.Scan() {
+		input := scanner.Text()
+		p.mu.Lock()
+		p.inputs = append(p.inputs, input)
+		p.mu.Unlock()
+
+		r, resps, ok := p.script.match(input)
+		if !ok {
+			continue
+		}
+		// Responses are written in the order their inputs were read
+		for _, resp := range resps {
+			if !p.respond(resp.Delay, resp.Lines) {
+				return
+			}
+		}
+		if r.crash {
+			p.Crash(r.code)
+			return
+		}
+	}
+}
+
+/* respond writes the given lines of output after a delay, reporting false if the process
+ * exits first.
+ */
+func (p *Process) respond(delay time.Duration, lines []string) bool {
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-p.done:
+			return false
+		}
+	}
+	return p.write(lines) == nil
+}
+
+func (p *Process) write(lines []string) error {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	for _, line := range lines {
+		if _, err := io.WriteString(p.stdoutW, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exit stops the process with the given Wait result, reporting false if it already exited.
+func (p *Process) exit(err error) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.done:
+		return false
+	default:
+	}
+	p.err = err
+	close(p.done)
+	p.stdoutW.Close()
+	p.stdinR.CloseWithError(ErrExited)
+	return true
+}
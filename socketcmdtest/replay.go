@@ -0,0 +1,159 @@
+package socketcmdtest
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/* NewScriptFromTranscript returns a Script which reproduces the output of the process in a
+ * recorded session. Each command (or line of terminal input) is answered once with the
+ * output which followed it in the transcript, with the recorded timing, and the process
+ * crashes after the input it exited on. Output recorded before the first input is emitted
+ * when the process starts.
+ */
+func NewScriptFromTranscript(events []socketcmd.Event) *Script {
+	s := NewScript()
+	if len(events) == 0 {
+		return s
+	}
+
+	var (
+		input  *rule
+		resps  []Response
+		last   = events[0].Time
+		inputs []rule
+	)
+	flush := func() {
+		if input != nil {
+			input.resps = resps
+			inputs = append(inputs, *input)
+		}
+		input, resps = nil, nil
+	}
+	for _, e := range events {
+		switch e.Kind {
+		case socketcmd.EventCommand, socketcmd.EventStdin:
+			flush()
+			input, last = &rule{input: e.Line, once: true}, e.Time
+		case socketcmd.EventStdout:
+			if input == nil {
+				s.Emit(e.Time.Sub(events[0].Time), e.Line)
+				continue
+			}
+			resps = append(resps, Response{Delay: e.Time.Sub(last), Lines: []string{e.Line}})
+			last = e.Time
+		case socketcmd.EventExit:
+			if input != nil {
+				input.crash, input.code = true, transcriptExitCode(e.Line)
+			}
+			flush()
+		}
+	}
+	flush()
+	for _, r := range inputs {
+		s.add(r)
+	}
+	return s
+}
+
+// transcriptExitCode returns the exit code in a recorded exit status, or 1 if there is none.
+func transcriptExitCode(status string) int {
+	var code int
+	if _, err := fmt.Sscanf(status, "process exited with code %d", &code); err == nil {
+		return code
+	}
+	return 1
+}
+
+/* Replay starts a Wrapper around a Script reproducing the given transcript, sends it the
+ * recorded commands and terminal input in order with their recorded headers, and returns
+ * the transcript of the replayed session. The optional setup functions are applied to the
+ * Wrapper before it is started.
+ */
+func Replay(t testing.TB, events []socketcmd.Event, setup ...func(socketcmd.Wrapper)) []socketcmd.Event {
+	t.Helper()
+	var buf lockedBuffer
+	setup = append([]func(socketcmd.Wrapper){func(w socketcmd.Wrapper) { w.Record(&buf) }}, setup...)
+	w, _ := NewWrapper(t, NewScriptFromTranscript(events), setup...)
+
+	for _, e := range events {
+		header := e.Header
+		switch {
+		case e.Kind == socketcmd.EventStdin:
+			header = "0:"
+		case e.Kind != socketcmd.EventCommand:
+			continue
+		case header == "":
+			header = socketcmd.DefaultHeader
+		}
+		c := socketcmd.NewClient(w.Addr().Network(), w.Addr().String(),
+			func([]string) string { return header })
+		if _, err := c.Send(strings.Fields(e.Line)...); err != nil {
+			t.Logf("replay %q: %v", e.Line, err)
+		}
+	}
+	w.Record(nil)
+
+	replayed, err := socketcmd.ReadTranscript(buf.reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return replayed
+}
+
+/* Lines returns the lines of the events of the given kinds, such as the output of a session
+ * for comparison with a replay.
+ */
+func Lines(events []socketcmd.Event, kinds ...socketcmd.EventKind) []string {
+	var lines []string
+	for _, e := range events {
+		for _, kind := range kinds {
+			if e.Kind == kind {
+				lines = append(lines, e.Line)
+				break
+			}
+		}
+	}
+	return lines
+}
+
+// lockedBuffer is a bytes.Buffer which is safe for concurrent use.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) reader() *bytes.Reader {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.NewReader(b.buf.Bytes())
+}
@@ -0,0 +1,165 @@
+package socketcmdtest
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+
+	"regexp"
+	"sync"
+	"time"
+)
+
+/* A Script describes the behaviour of fake Processes: the lines they respond with to given
+ * inputs, the unsolicited lines they emit, and the inputs which make them crash. Every
+ * Process created from the Script follows it, so it also applies after a restart.
+ */
+type Script struct {
+	mu      sync.Mutex
+	rules   []rule
+	emits   []Response
+	current *Process
+}
+
+/* A Response is a group of output lines written after a delay. The delay of each Response
+ * in a sequence is relative to the previous Response.
+ */
+type Response struct {
+	Delay time.Duration
+	Lines []string
+}
+
+// rule matches a line of input and describes the fake process's reaction to it.
+type rule struct {
+	input   string
+	pattern *regexp.Regexp
+	resps   []Response
+	crash   bool
+	code    int
+	once    bool
+}
+
+// NewScript returns a new, empty Script. Inputs which match no rule are ignored.
+func NewScript() *Script {
+	return &Script{}
+}
+
+// On responds to the given line of input with the given lines of output.
+func (s *Script) On(input string, lines ...string) *Script {
+	return s.OnDelay(input, 0, lines...)
+}
+
+// OnDelay responds to the given line of input with the given lines of output after a delay.
+func (s *Script) OnDelay(input string, delay time.Duration, lines ...string) *Script {
+	return s.add(rule{input: input, resps: []Response{{delay, lines}}})
+}
+
+/* OnMatch responds to lines of input matching the given regular expression. Each output
+ * line is a template expanded with the submatches of the input, as by Regexp.Expand
+ * (e.g. "Added $1 to the whitelist").
+ */
+func (s *Script) OnMatch(pattern string, delay time.Duration, lines ...string) *Script {
+	return s.add(rule{pattern: regexp.MustCompile(pattern), resps: []Response{{delay, lines}}})
+}
+
+/* Once responds to the next matching line of input with the given sequence of Responses,
+ * after which the rule is removed. Rules added by Once are consumed in the order they were
+ * added, so a repeated input can be given a different response each time.
+ */
+func (s *Script) Once(input string, resps ...Response) *Script {
+	return s.add(rule{input: input, resps: resps, once: true})
+}
+
+// CrashOn makes the process exit with the given code when it reads the given line of input.
+func (s *Script) CrashOn(input string, code int) *Script {
+	return s.add(rule{input: input, crash: true, code: code})
+}
+
+/* Emit writes the given unsolicited lines of output after a delay from the start of each
+ * process (such as a startup banner or a periodic log line).
+ */
+func (s *Script) Emit(delay time.Duration, lines ...string) *Script {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emits = append(s.emits, Response{delay, lines})
+	return s
+}
+
+func (s *Script) add(r rule) *Script {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = append(s.rules, r)
+	return s
+}
+
+/* match returns the first rule matching the given line of input, with its Responses
+ * expanded for the input. A matching rule added by Once is removed.
+ */
+func (s *Script) match(input string) (rule, []Response, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, r := range s.rules {
+		switch {
+		case r.pattern == nil && r.input == input:
+			if r.once {
+				s.rules = append(s.rules[:i:i], s.rules[i+1:]...)
+			}
+			return r, r.resps, true
+		case r.pattern != nil:
+			m := r.pattern.FindStringSubmatchIndex(input)
+			if m == nil {
+				continue
+			}
+			resps := make([]Response, len(r.resps))
+			for j, resp := range r.resps {
+				resps[j] = Response{Delay: resp.Delay, Lines: make([]string, len(resp.Lines))}
+				for k, tmpl := range resp.Lines {
+					resps[j].Lines[k] = string(r.pattern.ExpandString(nil, tmpl, input, m))
+				}
+			}
+			return r, resps, true
+		}
+	}
+	return rule{}, nil, false
+}
+
+// Current returns the most recent Process created from the Script, or nil if there is none.
+func (s *Script) Current() *Process {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
+/* ProcessFunc returns a function creating new Processes from the Script, for use with
+ * socketcmd.NewWithProcess.
+ */
+func (s *Script) ProcessFunc() socketcmd.ProcessFunc {
+	return func() (socketcmd.Process, error) {
+		return s.Process(), nil
+	}
+}
+
+// Process returns a new, unstarted Process following the Script.
+func (s *Script) Process() *Process {
+	p := newProcess(s)
+	s.mu.Lock()
+	s.current = p
+	s.mu.Unlock()
+	return p
+}
@@ -0,0 +1,129 @@
+package socketcmdtest
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+
+	"reflect"
+	"testing"
+	"time"
+)
+
+// lines returns the output lines of the Responses to the given input, or nil if none match.
+func lines(s *Script, input string) []string {
+	_, resps, ok := s.match(input)
+	if !ok {
+		return nil
+	}
+	var out []string
+	for _, resp := range resps {
+		out = append(out, resp.Lines...)
+	}
+	return out
+}
+
+func TestScriptOnceOrder(t *testing.T) {
+	s := NewScript().
+		Once("list", Response{Lines: []string{"first"}}).
+		Once("list", Response{Lines: []string{"second"}}).
+		On("list", "always")
+
+	for _, want := range []string{"first", "second", "always", "always"} {
+		if got := lines(s, "list"); !reflect.DeepEqual(got, []string{want}) {
+			t.Fatalf("match(list) = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestScriptOnMatch(t *testing.T) {
+	s := NewScript().
+		On("whitelist list", "alice").
+		OnMatch(`^whitelist add (\w+)$`, 0, "Added $1 to the whitelist")
+
+	if got, want := lines(s, "whitelist add bob"), []string{"Added bob to the whitelist"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("match(whitelist add bob) = %q, want %q", got, want)
+	}
+	if got, want := lines(s, "whitelist list"), []string{"alice"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("match(whitelist list) = %q, want %q", got, want)
+	}
+	if _, _, ok := s.match("whitelist remove bob"); ok {
+		t.Error("match(whitelist remove bob) matched a rule")
+	}
+}
+
+func TestScriptCrashOn(t *testing.T) {
+	s := NewScript().CrashOn("stop", 3)
+	r, _, ok := s.match("stop")
+	if !ok || !r.crash || r.code != 3 {
+		t.Errorf("match(stop) = %+v, %v, want a crash with code 3", r, ok)
+	}
+}
+
+func TestNewScriptFromTranscript(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	s := NewScriptFromTranscript([]socketcmd.Event{
+		{Time: at(0), Kind: socketcmd.EventStdout, Line: "Starting server"},
+		{Time: at(100), Kind: socketcmd.EventCommand, Line: "list"},
+		{Time: at(150), Kind: socketcmd.EventStdout, Line: "alice"},
+		{Time: at(250), Kind: socketcmd.EventStdout, Line: "bob"},
+		{Time: at(300), Kind: socketcmd.EventStdin, Line: "list"},
+		{Time: at(310), Kind: socketcmd.EventStdout, Line: "alice"},
+		{Time: at(400), Kind: socketcmd.EventCommand, Line: "stop"},
+		{Time: at(410), Kind: socketcmd.EventStdout, Line: "Stopping server"},
+		{Time: at(420), Kind: socketcmd.EventExit, Line: "process exited with code 3"},
+	})
+
+	if want := []Response{{0, []string{"Starting server"}}}; !reflect.DeepEqual(s.emits, want) {
+		t.Errorf("emits = %+v, want %+v", s.emits, want)
+	}
+	// Each recorded input is answered once, in order, with the recorded timing
+	_, resps, _ := s.match("list")
+	want := []Response{
+		{50 * time.Millisecond, []string{"alice"}},
+		{100 * time.Millisecond, []string{"bob"}},
+	}
+	if !reflect.DeepEqual(resps, want) {
+		t.Errorf("first match(list) = %+v, want %+v", resps, want)
+	}
+	_, resps, _ = s.match("list")
+	if want := []Response{{10 * time.Millisecond, []string{"alice"}}}; !reflect.DeepEqual(resps, want) {
+		t.Errorf("second match(list) = %+v, want %+v", resps, want)
+	}
+	if _, _, ok := s.match("list"); ok {
+		t.Error("third match(list) matched a rule")
+	}
+	r, _, ok := s.match("stop")
+	if !ok || !r.crash || r.code != 3 {
+		t.Errorf("match(stop) = %+v, %v, want a crash with code 3", r, ok)
+	}
+}
+
+func TestTranscriptExitCode(t *testing.T) {
+	for status, want := range map[string]int{
+		"process exited with code 0":  0,
+		"process exited with code 42": 42,
+		"signal: killed":              1,
+	} {
+		if got := transcriptExitCode(status); got != want {
+			t.Errorf("transcriptExitCode(%q) = %d, want %d", status, got, want)
+		}
+	}
+}
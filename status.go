@@ -0,0 +1,119 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* A status line is sent to the socket client in place of a response when the Handler
+ * refuses or is unable to forward a command to the wrapped process, or after a partial
+ * response if the process exits. Status lines are in the following format:
+ *
+ *	#socketcmd: <status> [retry=<ms>] <message>
+ */
+const StatusPrefix = "#socketcmd:"
+
+const (
+	// Client exceeded its configured rate limit
+	StatusRateLimited = "rate-limited"
+	// Wrapped process is not ready to receive commands
+	StatusStarting = "starting"
+	// Command is not allowed by the Handler's policy
+	StatusForbidden = "forbidden"
+	// Control command is not recognized
+	StatusUnknown = "unknown-command"
+	// Control command failed
+	StatusFailed = "failed"
+	// Wrapped process has exited; the message describes the exit status
+	StatusExited = "exited"
+)
+
+// A StatusError is returned by a Client when the Handler responds with a status line.
+type StatusError struct {
+	Status  string
+	Message string
+	// Suggested delay before the command is retried, if any
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return e.Status
+	}
+	return e.Status + ": " + e.Message
+}
+
+// String representation of the StatusError as a status line.
+func (e *StatusError) String() string {
+	s := StatusPrefix + " " + e.Status
+	if e.RetryAfter > 0 {
+		s += fmt.Sprintf(" retry=%d", e.RetryAfter/time.Millisecond)
+	}
+	if e.Message != "" {
+		s += " " + e.Message
+	}
+	return s
+}
+
+// ParseStatus extracts the StatusError from the given status line.
+func ParseStatus(line string) (*StatusError, bool) {
+	if !strings.HasPrefix(line, StatusPrefix) {
+		return nil, false
+	}
+	words := strings.SplitN(strings.TrimSpace(line[len(StatusPrefix):]), " ", 2)
+	e := &StatusError{Status: words[0]}
+	if len(words) < 2 {
+		return e, true
+	}
+	msg := words[1]
+	if strings.HasPrefix(msg, "retry=") {
+		s := strings.SplitN(msg, " ", 2)
+		if ms, err := strconv.Atoi(strings.TrimPrefix(s[0], "retry=")); err == nil {
+			e.RetryAfter = time.Duration(ms) * time.Millisecond
+			msg = ""
+			if len(s) > 1 {
+				msg = s[1]
+			}
+		}
+	}
+	e.Message = msg
+	return e, true
+}
+
+/* splitStatus separates the status line from the given response lines, returning it as the
+ * error. The Handler writes a status line first if the command was not forwarded (followed
+ * by the output tail if the process exited), or last if the response was interrupted, so
+ * output of the process which resembles a status line is left in the response.
+ */
+func splitStatus(lines []string) ([]string, error) {
+	if len(lines) == 0 {
+		return lines, nil
+	}
+	if status, ok := ParseStatus(lines[0]); ok {
+		return lines[1:], status
+	}
+	if status, ok := ParseStatus(lines[len(lines)-1]); ok {
+		return lines[:len(lines)-1], status
+	}
+	return lines, nil
+}
@@ -0,0 +1,170 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+/* SubscribeCommand streams the Handler's events on a socket connection as JSON lines, in the
+ * format of a session transcript, until the client closes the connection. Its arguments
+ * select the kinds of event to send (e.g. "!subscribe stdout"), or every kind if there are
+ * none. The subscription is acknowledged with a SessionEnd line.
+ */
+const SubscribeCommand = ControlPrefix + "subscribe"
+
+// Number of events buffered for each subscriber. Events are dropped for slow subscribers.
+var SubscriberBuffer = 256
+
+// Interval between keep-alive comments on idle WrapperAPI event streams.
+var StreamKeepAlive = 30 * time.Second
+
+// A subscriber receives the Handler's events of the selected kinds.
+type subscriber struct {
+	ch    chan Event
+	kinds map[EventKind]bool
+}
+
+/* subscribe registers a subscriber for the given kinds of event (every kind if none are
+ * given). The returned function cancels the subscription.
+ */
+func (h *handler) subscribe(kinds ...EventKind) (<-chan Event, func()) {
+	s := &subscriber{ch: make(chan Event, SubscriberBuffer)}
+	if len(kinds) > 0 {
+		s.kinds = make(map[EventKind]bool, len(kinds))
+		for _, kind := range kinds {
+			s.kinds[kind] = true
+		}
+	}
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[*subscriber]struct{})
+	}
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s.ch, func() {
+		h.mu.Lock()
+		delete(h.subs, s)
+		h.mu.Unlock()
+	}
+}
+
+// publish sends the event to the subscribers, without waiting for slow subscribers.
+func (h *handler) publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if s.kinds != nil && !s.kinds[e.Kind] {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+		}
+	}
+}
+
+// handleSubscribe streams events to the connection until the client closes it.
+func (h *handler) handleSubscribe(conn net.Conn, args []string, inSession bool) error {
+	if inSession {
+		status := &StatusError{Status: StatusFailed, Message: "cannot subscribe in a session"}
+		_, err := io.WriteString(conn, status.String()+"\n")
+		return err
+	}
+	kinds := make([]EventKind, len(args))
+	for i, arg := range args {
+		kinds[i] = EventKind(arg)
+	}
+	events, cancel := h.subscribe(kinds...)
+	defer cancel()
+	if _, err := io.WriteString(conn, SessionEnd+"\n"); err != nil {
+		return err
+	}
+
+	// The client sends nothing more, so a read returns when the connection is closed
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+	enc := json.NewEncoder(conn)
+	for {
+		select {
+		case e := <-events:
+			if err := enc.Encode(e); err != nil {
+				return nil
+			}
+		case <-closed:
+			return nil
+		}
+	}
+}
+
+func (c *client) Subscribe(ctx context.Context, kinds ...EventKind) (<-chan Event, error) {
+	conn, err := c.dialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := &session{conn: conn, r: bufio.NewReader(conn)}
+	args := []string{SubscribeCommand}
+	for _, kind := range kinds {
+		args = append(args, string(kind))
+	}
+	header := TargetHeader(c.Instance, DefaultHeader)
+	if _, err := s.roundTrip(ctx, header+" "+strings.Join(args, " ")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	events := make(chan Event)
+	// The connection is closed when the context is done, until the reader exits
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	go func() {
+		defer close(events)
+		defer close(done)
+		defer conn.Close()
+		dec := json.NewDecoder(s.r)
+		for {
+			var e Event
+			if err := dec.Decode(&e); err != nil {
+				return
+			}
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
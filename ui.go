@@ -0,0 +1,61 @@
+package socketcmd
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// Path of the web console served by a WrapperAPI.
+const UIPath = "/ui/"
+
+// Static assets of the web console
+//
+//go:embed ui
+var uiFiles embed.FS
+
+func (api *wrapperAPI) UI(enabled bool) {
+	api.ui.Store(enabled)
+}
+
+func (api *wrapperAPI) UIEndpoint(w http.ResponseWriter, r *http.Request) {
+	if !api.ui.Load() {
+		http.NotFound(w, r)
+		return
+	}
+	if !allowMethod(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(UIPath, "/"))
+	switch path {
+	case "":
+		// Relative links in the console require the trailing slash. The location is left
+		// relative, since the API may be mounted under another path.
+		w.Header().Set("Location", strings.TrimPrefix(UIPath, "/"))
+		w.WriteHeader(http.StatusMovedPermanently)
+	default:
+		files, _ := fs.Sub(uiFiles, "ui")
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = path
+		http.FileServer(http.FS(files)).ServeHTTP(w, r2)
+	}
+}
@@ -0,0 +1,337 @@
+/* socketcmd web console
+ *
+ * Shows the live output of the wrapped process from the stream endpoint, sends commands to
+ * the command endpoint, and completes them from the complete endpoint. Paths are relative to the console, so that it works wherever the
+ * WrapperAPI is mounted.
+ */
+(function () {
+	"use strict";
+
+	const HISTORY_KEY = "socketcmd.history";
+	const HISTORY_SIZE = 200;
+	const OUTPUT_LINES = 5000;
+	const HEALTH_INTERVAL = 5000;
+
+	const output = document.getElementById("output");
+	const form = document.getElementById("prompt");
+	const input = document.getElementById("input");
+	const hint = document.getElementById("hint");
+	const suggestions = document.getElementById("suggestions");
+	const streamBadge = document.getElementById("stream");
+	const healthBadge = document.getElementById("health");
+
+	let live = false;
+
+	/* Output */
+
+	function append(text, cls) {
+		const atBottom = output.scrollTop + output.clientHeight >= output.scrollHeight - 4;
+		const line = document.createElement("div");
+		line.textContent = text;
+		if (cls) {
+			line.className = cls;
+		}
+		output.appendChild(line);
+		while (output.childNodes.length > OUTPUT_LINES) {
+			output.removeChild(output.firstChild);
+		}
+		if (atBottom) {
+			output.scrollTop = output.scrollHeight;
+		}
+	}
+
+	function badge(el, text, state) {
+		el.textContent = text;
+		el.className = "badge " + state;
+	}
+
+	/* Live output */
+
+	function connect() {
+		const events = new EventSource("../stream?kind=stdout,stdin,command,control,exit");
+		events.onopen = function () {
+			live = true;
+			badge(streamBadge, "live", "ok");
+		};
+		events.onerror = function () {
+			live = false;
+			if (events.readyState === EventSource.CLOSED) {
+				// The stream was refused, so it is not retried
+				badge(streamBadge, "no live output", "bad");
+			} else {
+				badge(streamBadge, "reconnecting", "warn");
+			}
+		};
+		events.addEventListener("stdout", function (msg) {
+			append(JSON.parse(msg.data).line);
+		});
+		for (const kind of ["stdin", "command", "control"]) {
+			events.addEventListener(kind, function (msg) {
+				const e = JSON.parse(msg.data);
+				const source = e.source && e.source !== "@" ? "[" + e.source + "] " : "";
+				append(source + "> " + e.line, "command");
+			});
+		}
+		events.addEventListener("exit", function (msg) {
+			append("--- process exited: " + JSON.parse(msg.data).line, "notice");
+		});
+	}
+
+	/* Health */
+
+	async function checkHealth() {
+		try {
+			const resp = await fetch("../healthz", { cache: "no-store" });
+			const health = await resp.json();
+			if (health.ready) {
+				badge(healthBadge, "ready", "ok");
+			} else if (health.running) {
+				badge(healthBadge, "starting", "warn");
+			} else {
+				badge(healthBadge, "stopped", "bad");
+			}
+		} catch (err) {
+			badge(healthBadge, "unreachable", "bad");
+		}
+	}
+
+	function words(text) {
+		return text.trim().split(/\s+/).filter(Boolean);
+	}
+
+	/* Autocompletion */
+
+	let list = [];
+	let selected = -1;
+	let pending = 0;
+
+	/* fetchCandidates returns the completions of the last (partial) word of the input from
+	 * the complete endpoint, which completes the next word after a trailing space. Nothing is
+	 * completed if the request fails or the policy does not allow completion.
+	 */
+	async function fetchCandidates(text) {
+		try {
+			const q = encodeURIComponent(text.replace(/^\s+/, ""));
+			const resp = await fetch("../complete?q=" + q, { cache: "no-store" });
+			return resp.ok ? await resp.json() : [];
+		} catch (err) {
+			return [];
+		}
+	}
+
+	function apply(name) {
+		input.value = input.value.replace(/\S*$/, name + " ");
+		input.focus();
+		update();
+	}
+
+	function commonPrefix(names) {
+		let prefix = names[0];
+		for (const name of names) {
+			while (name.indexOf(prefix) !== 0) {
+				prefix = prefix.slice(0, -1);
+			}
+		}
+		return prefix;
+	}
+
+	async function complete() {
+		const found = await fetchCandidates(input.value);
+		if (found.length === 0) {
+			return;
+		}
+		if (found.length === 1) {
+			apply(found[0].name);
+			return;
+		}
+		// Complete the common prefix, then cycle through the candidates
+		const partial = input.value.match(/\S*$/)[0];
+		const prefix = commonPrefix(found.map(function (c) { return c.name; }));
+		if (prefix.length > partial.length && selected < 0) {
+			input.value = input.value.replace(/\S*$/, prefix);
+			update();
+			return;
+		}
+		list = found;
+		selected = (selected + 1) % list.length;
+		render(list);
+	}
+
+	function render(list) {
+		suggestions.textContent = "";
+		if (list.length === 1 && input.value.match(/\S*$/)[0] === list[0].name) {
+			return;
+		}
+		list.forEach(function (c, i) {
+			const item = document.createElement("li");
+			item.textContent = c.name + (c.description ? "  — " + c.description : "");
+			if (c.forbidden) {
+				item.className = "forbidden";
+				item.title = "Not allowed by the policy";
+			}
+			if (i === selected) {
+				item.classList.add("selected");
+				item.scrollIntoView({ block: "nearest" });
+			}
+			item.addEventListener("mousedown", function (ev) {
+				ev.preventDefault();
+				apply(c.name);
+			});
+			suggestions.appendChild(item);
+		});
+	}
+
+	// update refreshes the suggestions and the hint for the current input.
+	async function update() {
+		const text = input.value;
+		const args = words(text);
+		const seq = ++pending;
+		// The last complete word is among its own completions, which describe it
+		const [found, current] = await Promise.all([
+			text.trim() ? fetchCandidates(text) : [],
+			/\s$/.test(text) && args.length > 0 ? fetchCandidates(args.join(" ")) : null,
+		]);
+		if (seq !== pending) {
+			// A later update has replaced this one
+			return;
+		}
+		list = found;
+		selected = -1;
+		render(list);
+
+		const last = args[args.length - 1];
+		const match = (current || found).find(function (c) { return c.name === last; });
+		const forbidden = Boolean(match && match.forbidden);
+		input.classList.toggle("forbidden", forbidden);
+		hint.className = forbidden ? "error" : "";
+		if (forbidden) {
+			hint.textContent = "This command is not allowed by the policy.";
+		} else if (match) {
+			hint.textContent = [args.join(" "), match.description || ""]
+				.filter(Boolean).join("  — ");
+		} else {
+			hint.textContent = "";
+		}
+	}
+
+	/* History */
+
+	let history = [];
+	try {
+		history = JSON.parse(localStorage.getItem(HISTORY_KEY)) || [];
+	} catch (err) {
+		history = [];
+	}
+	let historyIndex = history.length;
+	let draft = "";
+
+	function remember(text) {
+		if (history[history.length - 1] !== text) {
+			history.push(text);
+			history = history.slice(-HISTORY_SIZE);
+			try {
+				localStorage.setItem(HISTORY_KEY, JSON.stringify(history));
+			} catch (err) {
+				// History is kept for this page only
+			}
+		}
+		historyIndex = history.length;
+		draft = "";
+	}
+
+	function recall(step) {
+		const index = historyIndex + step;
+		if (index < 0 || index > history.length) {
+			return;
+		}
+		if (historyIndex === history.length) {
+			draft = input.value;
+		}
+		historyIndex = index;
+		input.value = index === history.length ? draft : history[index];
+		update();
+		suggestions.textContent = "";
+	}
+
+	/* Commands */
+
+	async function send(text) {
+		const args = words(text);
+		if (args.length === 0) {
+			return;
+		}
+		remember(text.trim());
+		if (!live) {
+			append("> " + args.join(" "), "command");
+		}
+		try {
+			const resp = await fetch("../", {
+				method: "POST",
+				headers: { "Content-Type": "application/json", "Accept": "application/json" },
+				body: JSON.stringify({ command: args }),
+			});
+			if (!resp.ok) {
+				const message = (await resp.text()).trim();
+				if (resp.status === 403 || /forbidden|not allowed/.test(message)) {
+					append("Forbidden: " + args.join(" ") + " is not allowed by the policy", "error");
+				} else {
+					append(resp.status + " " + message, "error");
+				}
+				return;
+			}
+			// Process output is shown by the stream, but control responses are not output
+			const lines = await resp.json();
+			if (!live || args[0].charAt(0) === "!") {
+				for (const line of lines) {
+					append(line, "response");
+				}
+			}
+		} catch (err) {
+			append("Request failed: " + err.message, "error");
+		}
+	}
+
+	form.addEventListener("submit", function (ev) {
+		ev.preventDefault();
+		const text = input.value;
+		input.value = "";
+		update();
+		send(text);
+	});
+
+	input.addEventListener("input", update);
+	input.addEventListener("blur", function () {
+		suggestions.textContent = "";
+	});
+	input.addEventListener("keydown", function (ev) {
+		const list = suggestions.childNodes.length;
+		switch (ev.key) {
+		case "Tab":
+			ev.preventDefault();
+			complete();
+			break;
+		case "Enter":
+			if (selected >= 0 && list > 0) {
+				ev.preventDefault();
+				apply(list[selected].name);
+			}
+			break;
+		case "Escape":
+			selected = -1;
+			suggestions.textContent = "";
+			break;
+		case "ArrowUp":
+			ev.preventDefault();
+			recall(-1);
+			break;
+		case "ArrowDown":
+			ev.preventDefault();
+			recall(1);
+			break;
+		}
+	});
+
+	connect();
+	checkHealth();
+	setInterval(checkHealth, HEALTH_INTERVAL);
+})();
@@ -19,12 +19,28 @@ package socketcmd
 */
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"io"
+	"log"
 	"net"
 	"os"
 	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"time"
 )
 
+var (
+	ErrNotRunning = errors.New("the wrapped process is not running")
+	ErrRestarting = errors.New("the wrapped process is already restarting")
+)
+
+// Time allowed for the wrapped process to exit after being asked to stop
+var StopTimeout = 10 * time.Second
+
 /* A Wrapper provides I/O redirection for a process. Input to the Wrapper's network socket
  * will be forwarded to the process, with the resulting lines of stdout returned to the
  * socket client.
@@ -38,6 +54,54 @@ type Wrapper interface {
 	Start() error
 	// Wait for the wrapped process to exit.
 	Wait() error
+	// Limit the rate at which clients may send commands to the wrapped process.
+	Limit(RateLimiter)
+	// Health returns the current state of the wrapped process.
+	Health() Health
+	// Readiness configures how the Wrapper determines that the process is ready.
+	Readiness(Readiness)
+	// Gate holds socket commands after Start until the process is ready.
+	Gate(Gate)
+	// WaitReady blocks until the process is ready or the context is done.
+	WaitReady(context.Context) error
+	// Policy authorizes commands and control commands received on the socket.
+	Policy(*Policy)
+	// PolicyFile loads the Policy from the given file, which is reloaded by !reload-policy.
+	PolicyFile(path string) error
+	/* ParseFunc returns a ParseFunc which uses the Commands of the current Policy, e.g. for
+	 * ExposeAPI, and so follows the Policy when it is reloaded by !reload-policy.
+	 */
+	ParseFunc() ParseFunc
+	// Control registers a control command with the given name (without ControlPrefix).
+	Control(name string, fn ControlFunc)
+	// Record writes a transcript of the session to the given Writer (nil to stop).
+	Record(io.Writer)
+	// RecordFile appends a transcript of the session to the named file.
+	RecordFile(path string) error
+	// Correlate configures how responses are separated from unrelated process output.
+	Correlate(Correlation)
+
+	// Status returns the status of the wrapped process.
+	Status() ProcessStatus
+	// State returns the lifecycle state of the wrapped process.
+	State() State
+	// ExitCode returns the exit code of the wrapped process, or -1 if it has not exited
+	// or was terminated by a signal.
+	ExitCode() int
+	// Done returns a channel which is closed when the wrapped process exits for good.
+	Done() <-chan struct{}
+	// Restart stops the wrapped process and starts it again with the same configuration.
+	Restart() error
+	// Stop the wrapped process, killing it if it does not exit within StopTimeout.
+	Stop() error
+	// Signal sends the given signal to the wrapped process.
+	Signal(os.Signal) error
+	/* ForwardSignals relays the given signals (or DefaultForwardSignals) received by this
+	 * process to the wrapped process, which is started in its own process group. When the
+	 * wrapped process exits, any processes remaining in its group are killed. This must be
+	 * called before Start.
+	 */
+	ForwardSignals(...os.Signal)
 
 	// ExposeAPI for high-level network operations.
 	ExposeAPI(ParseFunc) WrapperAPI
@@ -61,17 +125,49 @@ func New(listener net.Listener, cmd *exec.Cmd) (Wrapper, error) {
 	if listener == nil || cmd == nil {
 		return nil, errors.New("missing required parameters")
 	}
-	// Create pipes for I/O redirection
-	stdin, err := cmd.StdinPipe()
+	w, err := newWrapper(listener, execSpawner(cmd))
 	if err != nil {
 		return nil, err
 	}
-	stdout, err := cmd.StdoutPipe()
+	return w, nil
+}
+
+/* NewWithProcess returns a new socket Wrapper around the Processes created by the given
+ * function, using the given net Listener. The function is called again to replace the
+ * Process each time it is restarted.
+ */
+func NewWithProcess(listener net.Listener, fn ProcessFunc) (Wrapper, error) {
+	if listener == nil || fn == nil {
+		return nil, errors.New("missing required parameters")
+	}
+	w, err := newWrapper(listener, fn)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func newWrapper(listener net.Listener, spawn ProcessFunc) (*wrapper, error) {
+	p, err := spawn()
 	if err != nil {
 		return nil, err
 	}
 	// Initialize socket Handler for the wrapped process
-	return &wrapper{cmd, NewHandler(listener, stdin, stdout)}, nil
+	w := &wrapper{
+		proc:    p,
+		spawn:   spawn,
+		h:       newHandler(listener, p.Stdin(), p.Stdout()),
+		state:   StateIdle,
+		stopped: make(chan struct{}),
+	}
+	w.h.Control("health", healthControl(w.Health))
+	w.h.Control("status", w.statusControl)
+	w.h.Control("pid", w.pidControl)
+	w.h.Control("uptime", w.uptimeControl)
+	w.h.Control("restart", w.restartControl)
+	w.h.Control("stop", w.stopControl)
+	w.h.Control("signal", w.signalControl)
+	return w, nil
 }
 
 /* Cmd returns a new exec.Cmd for use with a wrapper.
@@ -81,8 +177,21 @@ func Cmd(cmd string, args ...string) *exec.Cmd {
 }
 
 type wrapper struct {
-	Cmd *exec.Cmd
-	h   Handler
+	proc  Process
+	spawn ProcessFunc
+	h     *handler
+
+	mu         sync.Mutex
+	running    bool
+	restarting bool
+	restarts   int
+	state      State
+	started    time.Time
+	group      bool
+	forward    []os.Signal
+	exited     chan struct{} // closed when the current process exits
+	stopped    chan struct{} // closed when the process exits without restarting
+	err        error
 }
 
 func (w *wrapper) Addr() net.Addr {
@@ -90,22 +199,370 @@ func (w *wrapper) Addr() net.Addr {
 }
 
 func (w *wrapper) Run() error {
-	w.h.Start()
-	defer w.h.Close()
-	return w.Cmd.Run()
+	if err := w.Start(); err != nil {
+		w.h.Close()
+		return err
+	}
+	return w.Wait()
 }
 
 func (w *wrapper) Start() error {
+	w.prepare(w.proc)
+	// The Handler is only started once there is a process to attach it to
+	if err := w.start(w.proc); err != nil {
+		return err
+	}
 	w.h.Start()
-	return w.Cmd.Start()
+	w.track(w.proc)
+	if len(w.forward) > 0 {
+		go w.forwardSignals()
+	}
+	return nil
+}
+
+// prepare applies the Wrapper configuration to an unstarted process.
+func (w *wrapper) prepare(p Process) {
+	if ep, ok := p.(*execProcess); ok && w.group {
+		setProcessGroup(ep.cmd)
+	}
+}
+
+func (w *wrapper) ForwardSignals(sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = DefaultForwardSignals
+	}
+	w.group = true
+	w.forward = sigs
+}
+
+/* goroutine: relay signals received by this process to the wrapped process
+ *		os/signal -> process
+ */
+func (w *wrapper) forwardSignals() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, w.forward...)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case sig := <-ch:
+			if err := w.Signal(sig); err != nil && err != ErrNotRunning {
+				log.Println(err)
+			}
+		case <-w.stopped:
+			return
+		}
+	}
+}
+
+// track records the start of the given process and waits for it in the background.
+func (w *wrapper) track(p Process) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.running = true
+	w.state = StateRunning
+	w.started = time.Now()
+	w.exited = make(chan struct{})
+	w.h.metrics.process(true)
+	go w.wait(p, w.exited)
+}
+
+/* goroutine: wait for the given process to exit
+ *		process.Wait -> exited (+ stopped unless restarting)
+ */
+func (w *wrapper) wait(p Process, exited chan struct{}) {
+	err := p.Wait()
+	if pid := processPid(p); w.group && pid > 0 {
+		// Clean up any orphaned processes left in the process group
+		signalGroup(pid, os.Kill)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.running = false
+	w.h.metrics.process(false)
+	close(exited)
+	if !w.restarting {
+		w.err = err
+		w.state = StateExited
+		w.h.exit(exitDescription(err))
+		close(w.stopped)
+	}
 }
 
 func (w *wrapper) Wait() error {
+	if w.State() == StateIdle {
+		return errors.New("exec: not started")
+	}
 	defer w.h.Close()
-	return w.Cmd.Wait()
+	<-w.stopped
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *wrapper) Restart() error {
+	w.mu.Lock()
+	if w.restarting {
+		w.mu.Unlock()
+		return ErrRestarting
+	}
+	if !w.running {
+		w.mu.Unlock()
+		return ErrNotRunning
+	}
+	w.restarting = true
+	w.state = StateRestarting
+	exited := w.exited
+	w.mu.Unlock()
+
+	// Stop the current process, then start a new one attached to the Handler
+	w.terminate(exited)
+	p, err := w.restart()
+
+	w.mu.Lock()
+	w.restarting = false
+	if err != nil {
+		w.err = err
+		w.state = StateExited
+		w.h.exit("process failed to restart: " + err.Error())
+		close(w.stopped)
+		w.mu.Unlock()
+		return err
+	}
+	w.proc = p
+	w.restarts++
+	w.mu.Unlock()
+	w.track(p)
+	return nil
+}
+
+// restart starts a new process attached to the Handler.
+func (w *wrapper) restart() (Process, error) {
+	p, err := w.spawn()
+	if err != nil {
+		return nil, err
+	}
+	w.prepare(p)
+	w.h.attach(p.Stdin(), p.Stdout())
+	return p, w.start(p)
+}
+
+// start the given Process, copying its stderr (if captured) to the terminal.
+func (w *wrapper) start(p Process) error {
+	if err := p.Start(); err != nil {
+		return err
+	}
+	if stderr := p.Stderr(); stderr != nil {
+		go func() {
+			if _, err := io.Copy(os.Stderr, stderr); err != nil && !errors.Is(err, os.ErrClosed) {
+				log.Println(err)
+			}
+		}()
+	}
+	return nil
+}
+
+func (w *wrapper) Stop() error {
+	w.mu.Lock()
+	running, exited := w.running, w.exited
+	w.mu.Unlock()
+	if !running {
+		return ErrNotRunning
+	}
+	w.terminate(exited)
+	return nil
+}
+
+/* terminate asks the wrapped process to stop, killing it if it has not exited within the
+ * StopTimeout, and waits for it to exit.
+ */
+func (w *wrapper) terminate(exited <-chan struct{}) {
+	if err := w.Signal(terminateSignal); err == nil {
+		select {
+		case <-exited:
+			return
+		case <-time.After(StopTimeout):
+		}
+	}
+	w.Signal(os.Kill)
+	<-exited
+}
+
+func (w *wrapper) Signal(sig os.Signal) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.running {
+		return ErrNotRunning
+	}
+	if pid := processPid(w.proc); w.group && pid > 0 {
+		return signalGroup(pid, sig)
+	}
+	return w.proc.Signal(sig)
+}
+
+func (w *wrapper) Status() ProcessStatus {
+	status := ProcessStatus{Health: w.Health()}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	status.Restarts = w.restarts
+	status.State = w.state
+	status.ExitCode = -1
+	if w.state == StateExited {
+		status.ExitCode = exitCode(w.err)
+	}
+	if w.running {
+		status.PID = processPid(w.proc)
+		status.Uptime = time.Since(w.started)
+	}
+	return status
+}
+
+func (w *wrapper) State() State {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.state
+}
+
+func (w *wrapper) ExitCode() int {
+	return w.Status().ExitCode
+}
+
+func (w *wrapper) Done() <-chan struct{} {
+	return w.stopped
+}
+
+func (w *wrapper) Health() Health {
+	health := w.h.Health()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	health.Running = w.running
+	health.Ready = health.Ready && w.running
+	return health
+}
+
+func (w *wrapper) Readiness(r Readiness) {
+	w.h.Readiness(r)
+}
+
+func (w *wrapper) Gate(g Gate) {
+	w.h.Gate(g)
+}
+
+func (w *wrapper) WaitReady(ctx context.Context) error {
+	return w.h.WaitReady(ctx)
+}
+
+func (w *wrapper) Limit(limiter RateLimiter) {
+	w.h.Limit(limiter)
+}
+
+func (w *wrapper) Policy(p *Policy) {
+	w.h.Policy(p)
+}
+
+func (w *wrapper) PolicyFile(path string) error {
+	return w.h.PolicyFile(path)
+}
+
+func (w *wrapper) ParseFunc() ParseFunc {
+	return w.h.parse
+}
+
+func (w *wrapper) Control(name string, fn ControlFunc) {
+	w.h.Control(name, fn)
+}
+
+func (w *wrapper) Record(out io.Writer) {
+	w.h.Record(out)
+}
+
+func (w *wrapper) RecordFile(path string) error {
+	return w.h.RecordFile(path)
+}
+
+func (w *wrapper) Correlate(c Correlation) {
+	w.h.Correlate(c)
+}
+
+// !status - the process status as a JSON object
+func (w *wrapper) statusControl(_ []string) ([]string, error) {
+	b, err := json.Marshal(w.Status())
+	if err != nil {
+		return nil, err
+	}
+	return []string{string(b)}, nil
+}
+
+// !pid - the process ID of the wrapped process
+func (w *wrapper) pidControl(_ []string) ([]string, error) {
+	status := w.Status()
+	if !status.Running {
+		return nil, ErrNotRunning
+	}
+	return []string{strconv.Itoa(status.PID)}, nil
+}
+
+// !uptime - the time since the wrapped process was started
+func (w *wrapper) uptimeControl(_ []string) ([]string, error) {
+	status := w.Status()
+	if !status.Running {
+		return nil, ErrNotRunning
+	}
+	return []string{status.Uptime.String()}, nil
+}
+
+// !restart - restart the wrapped process
+func (w *wrapper) restartControl(_ []string) ([]string, error) {
+	if err := w.Restart(); err != nil {
+		return nil, err
+	}
+	return []string{"restarted"}, nil
+}
+
+// !stop - stop the wrapped process
+func (w *wrapper) stopControl(_ []string) ([]string, error) {
+	if err := w.Stop(); err != nil {
+		return nil, err
+	}
+	return []string{"stopped"}, nil
+}
+
+// !signal <name> - send a signal to the wrapped process
+func (w *wrapper) signalControl(args []string) ([]string, error) {
+	if len(args) < 1 {
+		return nil, errors.New("missing signal name")
+	}
+	sig, err := ParseSignal(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Signal(sig); err != nil {
+		return nil, err
+	}
+	return []string{"sent " + sig.String()}, nil
+}
+
+/* signalArgs replaces the signal of a !signal control command with its canonical name, so
+ * that a policy for "KILL" also applies to "9", "kill" and "SIGKILL". Other commands, and
+ * unknown signals, are returned unchanged.
+ */
+func signalArgs(args []string) []string {
+	if len(args) < 2 || args[0] != ControlPrefix+"signal" {
+		return args
+	}
+	sig, err := ParseSignal(args[1])
+	if err != nil {
+		return args
+	}
+	return append([]string{args[0], signalName(sig)}, args[2:]...)
 }
 
 func (w *wrapper) ExposeAPI(parser ParseFunc) WrapperAPI {
-	client := NewClient(w.Addr().Network(), w.Addr().String(), parser)
-	return &wrapperAPI{w, client}
+	c := NewClient(w.Addr().Network(), w.Addr().String(), parser).(*client)
+	// Commands are sent in-process, so that the Handler does not rate limit them again
+	c.dial = w.h.dialLocal
+	if l, ok := w.h.Socket.(*pipeListener); ok {
+		// Instances of a Multiplexer are only reachable in-process
+		c.dial = l.DialContext
+	}
+	return newWrapperAPI(w, c)
 }
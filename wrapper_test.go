@@ -0,0 +1,141 @@
+package socketcmd_test
+
+/*  Copyright 2017 Ryan Clarke
+
+    This file is part of Socketcmd.
+
+    Socketcmd is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    Socketcmd is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with Socketcmd.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+import (
+	"github.com/faceless-saint/go-socketcmd"
+	"github.com/faceless-saint/go-socketcmd/socketcmdtest"
+
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+/* newWrapper starts a Wrapper around the Script, with a Client which expects a response of
+ * one line to every command, so that responses do not wait for the default timeout.
+ */
+func newWrapper(t *testing.T, s *socketcmdtest.Script, setup ...func(socketcmd.Wrapper)) (
+	socketcmd.Wrapper, socketcmd.Client,
+) {
+	t.Helper()
+	w, c := socketcmdtest.NewWrapper(t, s, setup...)
+	c.Policy(&socketcmd.Argument{Header: "1:"})
+	return w, c
+}
+
+// allowControl allows the given control commands in addition to the DefaultControlPolicy.
+func allowControl(cmds ...string) func(socketcmd.Wrapper) {
+	return func(w socketcmd.Wrapper) {
+		control := socketcmd.Argument{Header: socketcmd.ForbiddenHeader, Args: map[string]socketcmd.Argument{}}
+		for name, arg := range socketcmd.DefaultControlPolicy.Args {
+			control.Args[name] = arg
+		}
+		for _, cmd := range cmds {
+			control.Args[cmd] = socketcmd.Argument{Header: socketcmd.DefaultHeader}
+		}
+		w.Policy(&socketcmd.Policy{Control: &control})
+	}
+}
+
+// contextTimeout returns a context which is done after the timeout or the end of the test.
+func contextTimeout(t *testing.T, timeout time.Duration) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// expect fails the test unless the response is the given lines.
+func expect(t *testing.T, lines []string, err error, want ...string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("response error: %v", err)
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("response %q, want %q", lines, want)
+	}
+}
+
+// expectStatus fails the test unless the error is a StatusError with the given status.
+func expectStatus(t *testing.T, err error, status string) *socketcmd.StatusError {
+	t.Helper()
+	var serr *socketcmd.StatusError
+	if !errors.As(err, &serr) || serr.Status != status {
+		t.Fatalf("error %v, want a %q status", err, status)
+	}
+	return serr
+}
+
+func TestWrapperConcurrent(t *testing.T) {
+	s := socketcmdtest.NewScript().OnMatch(`^echo (.*)$`, 0, "$1")
+	_, c := newWrapper(t, s)
+
+	// Exchanges from concurrent connections each receive their own response
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			word := fmt.Sprint(i)
+			lines, err := c.Send("echo", word)
+			if err == nil && (len(lines) != 1 || lines[0] != word) {
+				err = fmt.Errorf("echo %s: response %q", word, lines)
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// signalPolicy allows the !signal control command for every signal except KILL.
+func signalPolicy(w socketcmd.Wrapper) {
+	w.Policy(&socketcmd.Policy{Control: &socketcmd.Argument{
+		Header: socketcmd.ForbiddenHeader,
+		Args: map[string]socketcmd.Argument{
+			"!signal": {Header: "1:", Args: map[string]socketcmd.Argument{
+				"KILL": {Header: socketcmd.ForbiddenHeader},
+			}},
+		},
+	}})
+}
+
+func TestSignalPolicy(t *testing.T) {
+	w, c := newWrapper(t, socketcmdtest.NewScript(), signalPolicy)
+
+	// The policy applies to every form of the signal name
+	for _, sig := range []string{"KILL", "kill", "SIGKILL", "sigkill", "9"} {
+		expectStatus(t, c.Signal(sig), socketcmd.StatusForbidden)
+	}
+	if state := w.State(); state != socketcmd.StateRunning {
+		t.Fatalf("state = %s after forbidden signals, want %s", state, socketcmd.StateRunning)
+	}
+	if err := c.Signal("term"); err != nil {
+		t.Fatalf("allowed signal: %v", err)
+	}
+}